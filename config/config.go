@@ -15,6 +15,26 @@ type DHCPConfig struct {
 	InterfaceName                 string `env:"InterfaceName" envDefault:"en0"`
 	RetryRequestSec               int    `env:"RetryRequestSec" envDefault:"3"`
 	StopOnLeaseAcquisitionFailure bool   `env:"StopOnLeaseAcquisitionFailure" envDefault:"false"`
+	ReleaseOnStop                 bool   `env:"ReleaseOnStop" envDefault:"false"`
+	// InformIpAddr is statically configured address of the host. When set, the client does not acquire a lease, but
+	// obtains local configuration parameters with DHCPINFORM, see https://datatracker.ietf.org/doc/html/rfc2131#section-3.4
+	InformIpAddr string `env:"InformIpAddr"`
+	// LeaseDir is directory the client saves leases to, defaults to lease.DefaultLeaseDir
+	LeaseDir string `env:"LeaseDir"`
+
+	// Retransmission policy, see https://datatracker.ietf.org/doc/html/rfc2131#section-4.1
+	// Delay before first retransmission is RetransmitInitialSec, it doubles after every retransmission up to
+	// RetransmitMaxSec and is randomized by up to RetransmitRandomizationSec in either direction.
+	// RetransmitMaxAttempts limits number of DHCPREQUEST transmissions in SELECTING and INIT-REBOOT states.
+	RetransmitInitialSec       int `env:"RetransmitInitialSec" envDefault:"4"`
+	RetransmitMaxSec           int `env:"RetransmitMaxSec" envDefault:"64"`
+	RetransmitRandomizationSec int `env:"RetransmitRandomizationSec" envDefault:"1"`
+	RetransmitMaxAttempts      int `env:"RetransmitMaxAttempts" envDefault:"4"`
+
+	// Random delay before the first DHCPDISCOVER to desynchronize hosts started at the same moment,
+	// see https://datatracker.ietf.org/doc/html/rfc2131#section-4.4.1. Zero StartupDelayMaxSec disables the delay.
+	StartupDelayMinSec int `env:"StartupDelayMinSec" envDefault:"1"`
+	StartupDelayMaxSec int `env:"StartupDelayMaxSec" envDefault:"10"`
 }
 
 var GlobalDHCPConfig, _ = LoadConfig()
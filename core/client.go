@@ -5,6 +5,7 @@ import (
 	. "github.com/svishnyakoff/dhcpv4/packet"
 	"log"
 	"net"
+	"time"
 )
 
 type UdpClient struct {
@@ -38,6 +39,10 @@ func (c *UdpClient) Listen() error {
 }
 
 func (c *UdpClient) Stop() {
+	if c.conn == nil {
+		return
+	}
+
 	if err := c.conn.Close(); err != nil {
 		log.Println("Could not stop client gracefully", err)
 	}
@@ -68,3 +73,15 @@ func (c *UdpClient) Send(packet DHCPPacket, addr net.IP) error {
 
 	return nil
 }
+
+func (c *UdpClient) Receive(buf []byte, deadline time.Time) (int, error) {
+	bytesRead := 0
+	var err error = nil
+
+	for bytesRead <= 0 && err == nil {
+		c.conn.SetReadDeadline(deadline)
+		bytesRead, _, err = c.conn.ReadFromUDP(buf)
+	}
+
+	return bytesRead, err
+}
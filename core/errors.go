@@ -0,0 +1,132 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/svishnyakoff/dhcpv4/lease"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"net"
+)
+
+// Reasons client fails for. Returned errors and errors passed to failure listeners either are or wrap one of them,
+// use errors.Is to tell the reason and errors.As to get details from error types below.
+var (
+	// ErrNoOffer means no server offered an address, or offer selection policy rejected every offer
+	ErrNoOffer = errors.New("no acceptable offer received")
+	// ErrNak means server declined client request with DHCPNAK, see NakError
+	ErrNak = errors.New("server responded with DHCPNAK")
+	// ErrAddressConflict means acknowledged address is already used by another host, see AddressConflictError
+	ErrAddressConflict = errors.New("address is already in use")
+	// ErrTimeout means server did not respond to DHCPREQUEST or DHCPINFORM
+	ErrTimeout = errors.New("server did not respond")
+	// ErrMalformedPacket means received packet could not be decoded, see MalformedPacketError. Such packets are
+	// skipped, so failure listener does not receive it.
+	ErrMalformedPacket = errors.New("malformed DHCP packet")
+	// ErrTransport means transport failed to send or receive packet, see TransportError
+	ErrTransport = errors.New("transport failure")
+	// ErrLeaseExpired means client could neither renew nor rebind the lease before it expired, see LeaseExpiredError
+	ErrLeaseExpired = errors.New("lease expired")
+	// ErrNotBound means lease can be renewed or rebound on request only while client is in BOUND state
+	ErrNotBound = errors.New("client is not bound")
+	// ErrLinkChanged means LinkChanged was called while client waited for server, so client gave the exchange up to
+	// find out which network host is attached to
+	ErrLinkChanged = errors.New("link changed")
+)
+
+// NakError is returned when server responds with DHCPNAK. Message is the explanation server put into MESSAGE option.
+type NakError struct {
+	ServerIdentifier net.IP
+	Message          string
+}
+
+func (e *NakError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server %v responded with DHCPNAK", e.ServerIdentifier)
+	}
+
+	return fmt.Sprintf("server %v responded with DHCPNAK: %v", e.ServerIdentifier, e.Message)
+}
+
+func (e *NakError) Is(target error) bool {
+	return target == ErrNak
+}
+
+// AddressConflictError is returned when address acknowledged by server is used by another host on local network
+// segment. Client declines such address with DHCPDECLINE.
+type AddressConflictError struct {
+	Addr             net.IP
+	ServerIdentifier net.IP
+}
+
+func (e *AddressConflictError) Error() string {
+	return fmt.Sprintf("address %v acknowledged by server %v is already present on local network segment", e.Addr,
+		e.ServerIdentifier)
+}
+
+func (e *AddressConflictError) Is(target error) bool {
+	return target == ErrAddressConflict
+}
+
+// MalformedPacketError is returned when received packet could not be decoded
+type MalformedPacketError struct {
+	Err error
+}
+
+func (e *MalformedPacketError) Error() string {
+	return fmt.Sprintf("cannot decode dhcp packet: %v", e.Err)
+}
+
+func (e *MalformedPacketError) Is(target error) bool {
+	return target == ErrMalformedPacket
+}
+
+func (e *MalformedPacketError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError is returned when Transport fails. Op tells what transport was doing, such as "listen", "receive" or
+// "send DHCPDISCOVER".
+type TransportError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("transport failed to %v: %v", e.Op, e.Err)
+}
+
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransport
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// LeaseExpiredError is reported when client could neither renew nor rebind the lease before it expired
+type LeaseExpiredError struct {
+	Lease DHCPLease
+}
+
+func (e *LeaseExpiredError) Error() string {
+	return fmt.Sprintf("lease of %v expired at %v", e.Lease.IpAddr, e.Lease.GetLeaseExpirationMoment())
+}
+
+func (e *LeaseExpiredError) Is(target error) bool {
+	return target == ErrLeaseExpired
+}
+
+func newNakError(nak packet.DHCPPacket) *NakError {
+	err := &NakError{}
+
+	if serverIdentifier := nak.GetOption(option.SERVER_IDENTIFIER); serverIdentifier != nil {
+		err.ServerIdentifier = serverIdentifier.GetDataAsIP4()
+	}
+
+	if message := nak.GetOption(option.MESSAGE); message != nil {
+		err.Message = string(message.GetRawOptionValue())
+	}
+
+	return err
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"net"
+	"testing"
+)
+
+func TestErrorsMatchTheirReasons(t *testing.T) {
+	cause := errors.New("network is unreachable")
+	errs := map[error]error{
+		&NakError{}:                           ErrNak,
+		&AddressConflictError{}:               ErrAddressConflict,
+		&MalformedPacketError{Err: cause}:     ErrMalformedPacket,
+		&TransportError{"send", cause}:        ErrTransport,
+		&LeaseExpiredError{}:                  ErrLeaseExpired,
+		fmt.Errorf("wrapped: %w", ErrNoOffer): ErrNoOffer,
+	}
+
+	for err, reason := range errs {
+		wrapped := fmt.Errorf("request failed: %w", err)
+		assert.True(t, errors.Is(wrapped, reason), "%v is expected to be %v", err, reason)
+		assert.False(t, errors.Is(wrapped, ErrTimeout))
+	}
+
+	assert.True(t, errors.Is(&TransportError{"send", cause}, cause))
+	assert.True(t, errors.Is(&MalformedPacketError{Err: cause}, cause))
+}
+
+func TestNakErrorCarriesServerMessage(t *testing.T) {
+	nak := packet.DHCPPacket{}
+	nak.AddOption(option.NewMessageTypeOpt(option.DHCPNAK))
+	nak.AddOption(option.NewServerIdentifierOpt(net.ParseIP("192.168.0.1")))
+	nak.AddOption(option.NewMessageOpt("lease expired"))
+
+	var err error = newNakError(nak)
+	var nakErr *NakError
+
+	assert.True(t, errors.As(fmt.Errorf("renew failed: %w", err), &nakErr))
+	assert.Equal(t, net.ParseIP("192.168.0.1").To4(), nakErr.ServerIdentifier)
+	assert.Equal(t, "lease expired", nakErr.Message)
+	assert.Equal(t, "server 192.168.0.1 responded with DHCPNAK: lease expired", err.Error())
+}
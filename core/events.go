@@ -0,0 +1,86 @@
+package core
+
+import (
+	. "github.com/svishnyakoff/dhcpv4/lease"
+	"github.com/svishnyakoff/dhcpv4/packet"
+)
+
+// EventType tells what happened to the client or its lease
+type EventType int
+
+const (
+	// StateChanged means lease moved from one state to another, see Event.From and Event.To
+	StateChanged EventType = iota
+	// LeaseAcquired means server acknowledged requested offer or DHCPINFORM, so client holds a new lease
+	LeaseAcquired
+	// LeaseRenewed means server that granted the lease extended it, or confirmed lease requested after reboot
+	LeaseRenewed
+	// LeaseRebound means lease was extended by any server once renewal period was over
+	LeaseRebound
+	// LeaseExpired means client could neither renew nor rebind the lease, so the address must not be used anymore
+	LeaseExpired
+	// LeaseReleased means leased address was given back to the server with DHCPRELEASE
+	LeaseReleased
+	// NakReceived means server declined client request with DHCPNAK
+	NakReceived
+	// ConflictDetected means acknowledged address is used by another host, so client declined it
+	ConflictDetected
+	// AcquisitionFailed means client did not manage to obtain a lease, see Event.Err for the reason
+	AcquisitionFailed
+)
+
+func (t EventType) String() string {
+	types := []string{"STATE_CHANGED", "LEASE_ACQUIRED", "LEASE_RENEWED", "LEASE_REBOUND", "LEASE_EXPIRED",
+		"LEASE_RELEASED", "NAK_RECEIVED", "CONFLICT_DETECTED", "ACQUISITION_FAILED"}
+
+	if t < 0 || int(t) >= len(types) {
+		return "UNKNOWN"
+	}
+
+	return types[t]
+}
+
+// Event describes single change in client lifecycle. Lease is the snapshot of the lease at the moment of the event.
+// LeaseExpired and LeaseReleased events carry the lease that was lost, and are followed by StateChanged to INIT.
+type Event struct {
+	Type EventType
+	// From and To are set for StateChanged event
+	From State
+	To   State
+	// Packet is the packet that caused the event, such as DHCPACK or DHCPNAK. It is nil if there is no such packet.
+	Packet *packet.DHCPPacket
+	Lease  DHCPLease
+	// Err is set for events that mean failure, it wraps one of the errors from errors.go
+	Err error
+	// Diff tells what server changed in the lease, it is set for LeaseRenewed and LeaseRebound events
+	Diff *LeaseDiff
+}
+
+// emit notifies listeners about event of processing loop, that carries current lease
+func (p *ProcessingEngine) emit(event Event) {
+	event.Lease = p.Lease
+	p.notify(event)
+}
+
+func (p *ProcessingEngine) notify(event Event) {
+	for _, listener := range p.eventListeners {
+		listener(event)
+	}
+}
+
+// emitPacketEvent emits event caused by received packet
+func (p *ProcessingEngine) emitPacketEvent(eventType EventType, received packet.DHCPPacket, err error) {
+	p.emit(Event{Type: eventType, Packet: &received, Err: err})
+}
+
+// emitExtendedEvent emits LeaseRenewed or LeaseRebound event with the changes server made to previous lease
+func (p *ProcessingEngine) emitExtendedEvent(eventType EventType, ack packet.DHCPPacket, previous DHCPLease) {
+	diff := DiffLeases(previous, p.Lease)
+	p.emit(Event{Type: eventType, Packet: &ack, Diff: &diff})
+}
+
+// AddEventListener registers listener notified about every event of client lifecycle. Listener is called from the
+// goroutine that processes the lease, so it must not block.
+func (p *ProcessingEngine) AddEventListener(listener func(event Event)) {
+	p.eventListeners = append(p.eventListeners, listener)
+}
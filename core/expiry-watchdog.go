@@ -0,0 +1,102 @@
+package core
+
+import (
+	. "github.com/svishnyakoff/dhcpv4/lease"
+	"github.com/svishnyakoff/dhcpv4/util/timers"
+	"log"
+)
+
+// watchExpiry runs alongside processing loop and reports the lease as expired exactly at its expiration moment, even
+// if processing loop is busy retransmitting DHCPREQUEST. Processing loop then notifies listeners, gives up the lease
+// and starts over from INIT state.
+func (p *ProcessingEngine) watchExpiry() {
+	for {
+		select {
+		case <-p.expiryTimer.C():
+			p.expire()
+		case <-p.terminate:
+			return
+		}
+	}
+}
+
+// armExpiryWatchdog schedules expiry of the lease that has just been bound
+func (p *ProcessingEngine) armExpiryWatchdog() {
+	p.lock.Lock()
+	p.watchedLease = p.Lease
+	p.leaseExpired = false
+	p.expiredLease = DHCPLease{}
+	p.lock.Unlock()
+	p.drainExpiry()
+
+	timers.SafeReset(p.expiryTimer, p.Lease.GetLeaseExpirationMoment().Sub(p.Clock.Now()))
+}
+
+// disarmExpiryWatchdog cancels expiry of the lease client gave up
+func (p *ProcessingEngine) disarmExpiryWatchdog() {
+	p.lock.Lock()
+	p.watchedLease = DHCPLease{}
+	p.leaseExpired = false
+	p.expiredLease = DHCPLease{}
+	p.lock.Unlock()
+	p.drainExpiry()
+
+	timers.SafeStop(p.expiryTimer)
+}
+
+// drainExpiry drops wake up signal of the lease that is no longer held, so it does not interrupt waiting for packets
+// of the next lease
+func (p *ProcessingEngine) drainExpiry() {
+	select {
+	case <-p.expiry:
+	default:
+	}
+}
+
+// expire marks watched lease as expired and wakes processing loop up. Lease is marked once, no matter whether
+// watchdog or processing loop notices the expiry first.
+func (p *ProcessingEngine) expire() {
+	p.lock.Lock()
+	expired := p.watchedLease
+	if p.stopped || expired.ServerIdentifier == nil || p.Clock.Now().Before(expired.GetLeaseExpirationMoment()) {
+		p.lock.Unlock()
+		return
+	}
+	p.watchedLease = DHCPLease{}
+	p.expiredLease = expired
+	p.leaseExpired = true
+	p.lock.Unlock()
+
+	log.Println("lease expired at", expired.GetLeaseExpirationMoment())
+
+	select {
+	case p.expiry <- 1:
+	default:
+	}
+}
+
+func (p *ProcessingEngine) isLeaseExpired() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.leaseExpired
+}
+
+// reportLeaseExpiry notifies listeners about the lease marked as expired and reports whether there is one. It is
+// called by processing loop, so listeners are never called from watchdog goroutine.
+func (p *ProcessingEngine) reportLeaseExpiry() bool {
+	p.lock.Lock()
+	expired, ok := p.expiredLease, p.leaseExpired
+	p.expiredLease = DHCPLease{}
+	p.leaseExpired = false
+	p.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	err := &LeaseExpiredError{Lease: expired}
+	p.notify(Event{Type: LeaseExpired, Lease: expired, Err: err})
+	p.onFailure(err)
+	return true
+}
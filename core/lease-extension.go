@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	. "github.com/svishnyakoff/dhcpv4/lease"
+)
+
+// extension is the request to renew or rebind the lease right away instead of waiting for T1 or T2
+type extension struct {
+	rebind bool
+	done   chan extensionResult
+}
+
+type extensionResult struct {
+	lease DHCPLease
+	err   error
+}
+
+// Renew makes bound client renew the lease right away, for example once server side reservation changed, and waits
+// for server to respond. Context bounds the wait, it does not stop the renewal.
+func (p *ProcessingEngine) Renew(ctx context.Context) (DHCPLease, error) {
+	return p.extend(ctx, &extension{rebind: false, done: make(chan extensionResult, 1)})
+}
+
+// Rebind makes bound client broadcast DHCPREQUEST to all servers right away, the way it does once T2 is reached,
+// and waits for any server to respond. Context bounds the wait, it does not stop the rebinding.
+func (p *ProcessingEngine) Rebind(ctx context.Context) (DHCPLease, error) {
+	return p.extend(ctx, &extension{rebind: true, done: make(chan extensionResult, 1)})
+}
+
+func (p *ProcessingEngine) extend(ctx context.Context, ext *extension) (DHCPLease, error) {
+	p.lock.Lock()
+	if p.stopped || p.done == nil {
+		p.lock.Unlock()
+		return DHCPLease{}, fmt.Errorf("processing engine is not running")
+	}
+	// lease is modified by processing loop, so its state is taken from the copy published under the lock
+	if p.publishedLease.State != BOUND {
+		state := p.publishedLease.State
+		p.lock.Unlock()
+		return DHCPLease{}, fmt.Errorf("lease cannot be extended in %v state: %w", state, ErrNotBound)
+	}
+	if p.pendingExtension != nil {
+		p.lock.Unlock()
+		return DHCPLease{}, fmt.Errorf("lease is being extended already")
+	}
+	p.pendingExtension = ext
+	p.lock.Unlock()
+
+	select {
+	case p.extendNow <- 1:
+	default:
+	}
+
+	select {
+	case result := <-ext.done:
+		return result.lease, result.err
+	case <-ctx.Done():
+		p.lock.Lock()
+		if p.pendingExtension == ext {
+			p.pendingExtension = nil
+		}
+		p.lock.Unlock()
+		return DHCPLease{}, ctx.Err()
+	case <-p.terminate:
+		return DHCPLease{}, fmt.Errorf("processing engine has been stopped")
+	}
+}
+
+func (p *ProcessingEngine) peekExtension() *extension {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.pendingExtension
+}
+
+// finishExtension reports outcome of requested renewal or rebinding, if there is one in progress
+func (p *ProcessingEngine) finishExtension(err error) {
+	p.lock.Lock()
+	ext := p.pendingExtension
+	p.pendingExtension = nil
+	lease := p.publishedLease
+	p.lock.Unlock()
+
+	if ext == nil {
+		return
+	}
+
+	if err != nil {
+		ext.done <- extensionResult{err: err}
+	} else {
+		ext.done <- extensionResult{lease: lease}
+	}
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"errors"
+	. "github.com/svishnyakoff/dhcpv4/lease"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	netUtils "github.com/svishnyakoff/dhcpv4/util/net-utils"
+	"log"
+	"net"
+	"sort"
+)
+
+// GatewayResolver finds hardware address of the default gateway with ARP request sent on behalf of sender, the address
+// of the lease whose network is checked. So gateway is probed even if the address is not configured on the interface,
+// see https://datatracker.ietf.org/doc/html/rfc4436#section-2.2. It returns netUtils.ErrNoArpReply if gateway does not
+// respond, any other error means gateway could not be probed. By default the request is sent through the interface
+// from DHCPConfig.
+type GatewayResolver func(gateway net.IP, sender net.IP) (net.HardwareAddr, error)
+
+var errNoGateway = errors.New("lease does not tell the default gateway")
+var errGatewayNotProbed = errors.New("gateway of the lease has not been probed")
+
+// gateway is the default gateway of the network client is attached to, it is remembered once gateway responds, so
+// lease is renewed and released without probing gateway again
+type gateway struct {
+	addr         net.IP
+	hardwareAddr net.HardwareAddr
+}
+
+// LinkChanged tells engine that host might have been attached to another network, for example after laptop was
+// moved from office to home. Engine then picks cached lease of the network it is attached to or discovers a new one.
+// Network detection follows https://datatracker.ietf.org/doc/html/rfc4436
+func (p *ProcessingEngine) LinkChanged() {
+	p.lock.Lock()
+	p.linkChanged = true
+	p.lock.Unlock()
+
+	select {
+	case p.linkChange <- 1:
+	default:
+	}
+}
+
+func (p *ProcessingEngine) isLinkChanged() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.linkChanged
+}
+
+// takeLinkChange reports whether link changed since it was taken last time. Wake up signal of the change is dropped
+// as well, so it does not interrupt waiting once the change is handled.
+func (p *ProcessingEngine) takeLinkChange() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	select {
+	case <-p.linkChange:
+	default:
+	}
+
+	changed := p.linkChanged
+	p.linkChanged = false
+	return changed
+}
+
+// cacheLease remembers current lease as the lease of the network client is attached to. Lease is not cached if
+// server did not tell the default gateway or the gateway could not be probed.
+func (p *ProcessingEngine) cacheLease() {
+	network, err := p.networkOf(p.Lease, true)
+	if err != nil {
+		log.Println("failed to find network of lease", p.Lease.IpAddr, err)
+		return
+	}
+
+	if err := p.LeaseStore.Save(p.LeaseKey.ForNetwork(network), p.Lease); err != nil {
+		log.Println("failed to cache lease of network", network, err)
+	}
+}
+
+// networkOf returns key of the network lease belongs to, see NetworkKey. Gateway of the lease is probed unless it is
+// the gateway client already found, or probe is false, in which case errGatewayNotProbed is returned.
+func (p *ProcessingEngine) networkOf(l DHCPLease, probe bool) (string, error) {
+	router := l.Offer.GetOption(option.ROUTER_OPT)
+	if router == nil || l.ServerIdentifier == nil {
+		return "", errNoGateway
+	}
+
+	addr := router.GetDataAsIP4()
+	if !p.gateway.addr.Equal(addr) {
+		if !probe {
+			return "", errGatewayNotProbed
+		}
+
+		hardwareAddr, err := p.GatewayResolver(addr, l.IpAddr)
+		if err != nil {
+			return "", err
+		}
+
+		p.gateway = gateway{addr: addr, hardwareAddr: hardwareAddr}
+	}
+
+	return NetworkKey(l.ServerIdentifier, p.gateway.hardwareAddr), nil
+}
+
+// detectNetwork checks which of the cached networks host is attached to and switches to lease of that network in
+// INIT-REBOOT state. If host is attached to none of them, client falls back to DHCPDISCOVER. Lease of unknown
+// network, for example the one imported from another DHCP client, is left intact. Current lease is left intact as
+// well if gateway could not be probed, so server tells whether the lease is still valid.
+func (p *ProcessingEngine) detectNetwork() {
+	// host might have been moved, so gateway found before is probed again and address is discovered from scratch
+	p.gateway = gateway{}
+	p.discovery = nil
+
+	cached := p.cachedLeases()
+	if len(cached) == 0 {
+		return
+	}
+
+	currentIsCached := false
+	probeFailed := false
+	for _, c := range cached {
+		if c.lease.IpAddr.Equal(p.Lease.IpAddr) && c.lease.ServerIdentifier.Equal(p.Lease.ServerIdentifier) {
+			currentIsCached = true
+		}
+
+		network, err := p.networkOf(c.lease, true)
+		if err == nil && network == c.network {
+			log.Println("host is attached to known network", network)
+			p.Lease = c.lease
+			p.UpdateState(INIT_REBOOT)
+			return
+		}
+
+		if err != nil && !errors.Is(err, netUtils.ErrNoArpReply) {
+			log.Println("could not probe gateway of network", c.network, err)
+			probeFailed = true
+		}
+	}
+
+	if probeFailed || p.Lease.State == INIT_REBOOT && !currentIsCached {
+		return
+	}
+
+	log.Println("host is attached to none of", len(cached), "known networks")
+	if p.Lease.State != INIT {
+		p.UpdateState(INIT)
+	}
+}
+
+type cachedLease struct {
+	network string
+	lease   DHCPLease
+}
+
+// cachedLeases returns leases of known networks, the most recent lease comes first
+func (p *ProcessingEngine) cachedLeases() []cachedLease {
+	keys, err := p.LeaseStore.List()
+	if err != nil {
+		log.Println("failed to list cached leases", err)
+		return nil
+	}
+
+	leases := make([]cachedLease, 0, len(keys))
+	for _, key := range keys {
+		if key.Network == "" || key.ForNetwork("") != p.LeaseKey.ForNetwork("") {
+			continue
+		}
+
+		l, err := p.LeaseStore.Load(key)
+		if err != nil {
+			log.Println("failed to load cached lease", key, err)
+			continue
+		}
+
+		leases = append(leases, cachedLease{network: key.Network, lease: l})
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].lease.LeaseInitTime.After(leases[j].lease.LeaseInitTime)
+	})
+
+	return leases
+}
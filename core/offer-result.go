@@ -0,0 +1,53 @@
+package core
+
+import (
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"net"
+)
+
+// OfferOutcome tells what happened when the client requested an offered address
+type OfferOutcome int
+
+const (
+	// OfferAccepted means server acknowledged the request and the client bound the address
+	OfferAccepted OfferOutcome = iota
+	// OfferNaked means server declined the request with DHCPNAK
+	OfferNaked
+	// OfferDeclined means server acknowledged address that is already in use, so the client sent DHCPDECLINE
+	OfferDeclined
+	// OfferTimedOut means server did not respond to the request
+	OfferTimedOut
+	// OfferInvalid means offer could not be requested, for example it misses server identifier
+	OfferInvalid
+	// OfferFailed means request could not be completed for other reason, for example engine was stopped
+	OfferFailed
+)
+
+func (o OfferOutcome) String() string {
+	outcomes := []string{"ACCEPTED", "NAKED", "DECLINED", "TIMED_OUT", "INVALID", "FAILED"}
+
+	if o < 0 || int(o) >= len(outcomes) {
+		return "UNKNOWN"
+	}
+
+	return outcomes[o]
+}
+
+// OfferResult records outcome of requesting single offer
+type OfferResult struct {
+	Offer            packet.DHCPPacket
+	ServerIdentifier net.IP
+	Outcome          OfferOutcome
+	Err              error
+}
+
+func newOfferResult(offer packet.DHCPPacket, outcome OfferOutcome, err error) OfferResult {
+	result := OfferResult{Offer: offer, Outcome: outcome, Err: err}
+
+	if serverIdentifier := offer.GetOption(option.SERVER_IDENTIFIER); serverIdentifier != nil {
+		result.ServerIdentifier = serverIdentifier.GetDataAsIP4()
+	}
+
+	return result
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	. "github.com/svishnyakoff/dhcpv4/lease"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"net"
+	"sort"
+)
+
+// OfferSelector decides which offer the client requests address from. Select receives all offers collected during
+// OfferWindowSec interval in order of arrival along with the last lease the client held, and returns acceptable
+// offers in the order of preference. The client requests the first returned offer.
+type OfferSelector interface {
+	Select(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket
+}
+
+// OfferSelectorFunc lets ordinary function be used as OfferSelector
+type OfferSelectorFunc func(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket
+
+func (f OfferSelectorFunc) Select(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket {
+	return f(offers, previous)
+}
+
+// FirstOffer prefers offers in order of arrival. This is default policy.
+func FirstOffer() OfferSelector {
+	return OfferSelectorFunc(func(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket {
+		return offers
+	})
+}
+
+// LongestLease prefers offers with longer lease time
+func LongestLease() OfferSelector {
+	return OfferSelectorFunc(func(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket {
+		return sortOffers(offers, func(o packet.DHCPPacket) int {
+			leaseTime := o.GetOption(option.IP_ADDR_LEASE_TIME)
+			if leaseTime == nil {
+				return 0
+			}
+
+			return -int(leaseTime.GetDataAsUint())
+		})
+	})
+}
+
+// PreferredServers prefers offers from given servers, in order servers are listed. Offers from other servers are
+// still acceptable, but go after offers from preferred servers.
+func PreferredServers(serverIds ...net.IP) OfferSelector {
+	return OfferSelectorFunc(func(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket {
+		return sortOffers(offers, func(o packet.DHCPPacket) int {
+			serverId := o.GetOption(option.SERVER_IDENTIFIER)
+
+			for i, id := range serverIds {
+				if serverId != nil && id.Equal(serverId.GetDataAsIP4()) {
+					return i
+				}
+			}
+
+			return len(serverIds)
+		})
+	})
+}
+
+// PreferPreviousAddress prefers offer of the address the client held last time
+func PreferPreviousAddress() OfferSelector {
+	return OfferSelectorFunc(func(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket {
+		return sortOffers(offers, func(o packet.DHCPPacket) int {
+			if previous.IpAddr != nil && previous.IpAddr.Equal(net.IP(o.Yiaddr[:])) {
+				return 0
+			}
+
+			return 1
+		})
+	})
+}
+
+// RequireOptions rejects offers that do not contain all given options
+func RequireOptions(options ...option.OptionType) OfferSelector {
+	return OfferSelectorFunc(func(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket {
+		accepted := make([]packet.DHCPPacket, 0, len(offers))
+
+		for _, o := range offers {
+			if hasOptions(o, options) {
+				accepted = append(accepted, o)
+			}
+		}
+
+		return accepted
+	})
+}
+
+// Chain applies selectors one after another, so every selector receives offers left by previous one. Built-in
+// selectors keep relative order of offers they consider equal, thus the last selector in chain defines primary order.
+func Chain(selectors ...OfferSelector) OfferSelector {
+	return OfferSelectorFunc(func(offers []packet.DHCPPacket, previous DHCPLease) []packet.DHCPPacket {
+		for _, s := range selectors {
+			offers = s.Select(offers, previous)
+		}
+
+		return offers
+	})
+}
+
+// sortOffers returns copy of offers stable sorted by given rank, offers with lower rank go first
+func sortOffers(offers []packet.DHCPPacket, rank func(o packet.DHCPPacket) int) []packet.DHCPPacket {
+	sorted := append([]packet.DHCPPacket{}, offers...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+
+	return sorted
+}
+
+func hasOptions(p packet.DHCPPacket, options []option.OptionType) bool {
+	for _, o := range options {
+		if p.GetOption(o) == nil {
+			return false
+		}
+	}
+
+	return true
+}
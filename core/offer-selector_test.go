@@ -0,0 +1,84 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/svishnyakoff/dhcpv4/lease"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"github.com/svishnyakoff/dhcpv4/util/converter"
+	"net"
+	"testing"
+)
+
+func TestLongestLease(t *testing.T) {
+	offers := []packet.DHCPPacket{
+		newOffer("10.0.0.1", "10.0.0.10", 100),
+		newOffer("10.0.0.2", "10.0.0.20", 300),
+		newOffer("10.0.0.3", "10.0.0.30", 200),
+	}
+
+	selected := LongestLease().Select(offers, lease.DHCPLease{})
+
+	assert.Equal(t, []packet.DHCPPacket{offers[1], offers[2], offers[0]}, selected)
+}
+
+func TestPreferredServers(t *testing.T) {
+	offers := []packet.DHCPPacket{
+		newOffer("10.0.0.1", "10.0.0.10", 100),
+		newOffer("10.0.0.2", "10.0.0.20", 100),
+		newOffer("10.0.0.3", "10.0.0.30", 100),
+	}
+
+	selected := PreferredServers(net.ParseIP("10.0.0.3"), net.ParseIP("10.0.0.2")).Select(offers, lease.DHCPLease{})
+
+	assert.Equal(t, []packet.DHCPPacket{offers[2], offers[1], offers[0]}, selected)
+}
+
+func TestPreferPreviousAddress(t *testing.T) {
+	offers := []packet.DHCPPacket{
+		newOffer("10.0.0.1", "10.0.0.10", 100),
+		newOffer("10.0.0.2", "10.0.0.20", 100),
+	}
+
+	selected := PreferPreviousAddress().Select(offers, lease.DHCPLease{IpAddr: net.ParseIP("10.0.0.20").To4()})
+
+	assert.Equal(t, []packet.DHCPPacket{offers[1], offers[0]}, selected)
+}
+
+func TestRequireOptions(t *testing.T) {
+	withRouter := newOffer("10.0.0.2", "10.0.0.20", 100)
+	withRouter.AddOption(option.DHCPOption{Data: []byte{3, 4, 10, 0, 0, 254}, ID: option.ROUTER_OPT.String()})
+	offers := []packet.DHCPPacket{
+		newOffer("10.0.0.1", "10.0.0.10", 100),
+		withRouter,
+	}
+
+	selected := RequireOptions(option.ROUTER_OPT, option.IP_ADDR_LEASE_TIME).Select(offers, lease.DHCPLease{})
+
+	assert.Equal(t, []packet.DHCPPacket{withRouter}, selected)
+}
+
+func TestChainOfSelectors(t *testing.T) {
+	offers := []packet.DHCPPacket{
+		newOffer("10.0.0.1", "10.0.0.10", 100),
+		newOffer("10.0.0.2", "10.0.0.20", 300),
+		newOffer("10.0.0.3", "10.0.0.30", 300),
+	}
+
+	selected := Chain(LongestLease(), PreferredServers(net.ParseIP("10.0.0.3"))).Select(offers, lease.DHCPLease{})
+
+	assert.Equal(t, []packet.DHCPPacket{offers[2], offers[1], offers[0]}, selected)
+}
+
+func newOffer(serverId string, addr string, leaseTime uint32) packet.DHCPPacket {
+	offer := packet.DHCPPacket{
+		Op:     packet.REPLY,
+		Yiaddr: converter.IP2Array(net.ParseIP(addr).To4()),
+	}
+
+	offer.AddOption(option.NewMessageTypeOpt(option.DHCPOFFER))
+	offer.AddOption(option.NewIpAddrLeaseTime(leaseTime))
+	offer.AddOption(option.NewServerIdentifierOpt(net.ParseIP(serverId)))
+
+	return offer
+}
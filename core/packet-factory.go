@@ -15,6 +15,9 @@ var hardwareInterface = netUtils.GetPreferredOutboundInterface()
 
 type DHCPPacketFactory struct {
 	Config DHCPConfig
+	// Secs is a value of 'secs' field, that is seconds elapsed since client began address acquisition or renewal
+	// process. DHCPDECLINE always has zero 'secs' field.
+	Secs uint16
 }
 
 func (f *DHCPPacketFactory) Discover() (*DHCPPacket, TxId) {
@@ -28,7 +31,7 @@ func (f *DHCPPacketFactory) Discover() (*DHCPPacket, TxId) {
 		// the value is 6
 		// https://datatracker.ietf.org/doc/html/rfc2131#page-34, http://www.tcpipguide.com/free/t_DHCPMessageFormat.htm
 		Xid:    tx,
-		Secs:   0,
+		Secs:   f.Secs,
 		Ciaddr: converter.IP2Array(net.IPv4zero),
 		Chaddr: converter.Hardware2Array(netUtils.GetHardwareAddr(hardwareInterface.Name)),
 	}
@@ -50,7 +53,7 @@ func (f *DHCPPacketFactory) RequestForRenew(lease DHCPLease) (*DHCPPacket, TxId)
 		// the value is 6
 		// https://datatracker.ietf.org/doc/html/rfc2131#page-34, http://www.tcpipguide.com/free/t_DHCPMessageFormat.htm
 		Xid:    tx,
-		Secs:   0,
+		Secs:   f.Secs,
 		Ciaddr: converter.IP2Array(lease.IpAddr),
 		Chaddr: converter.Hardware2Array(netUtils.GetHardwareAddr(hardwareInterface.Name)),
 	}
@@ -84,7 +87,7 @@ func (f *DHCPPacketFactory) RequestForOffer(offer DHCPPacket) (*DHCPPacket, TxId
 		//     DHCPDISCOVER message, the DHCPREQUEST message MUST use the same
 		//     value in the DHCP message header's 'secs' field and be sent to the
 		//     same IP broadcast address as the original DHCPDISCOVER message."
-		Secs: 0,
+		Secs: f.Secs,
 
 		Ciaddr: converter.IP2Array(net.IPv4zero), // 0.0.0.0
 		Chaddr: converter.Hardware2Array(netUtils.GetHardwareAddr(hardwareInterface.Name)),
@@ -117,7 +120,7 @@ func (f *DHCPPacketFactory) RequestForReboot(lease DHCPLease) (*DHCPPacket, TxId
 		//     DHCPDISCOVER message, the DHCPREQUEST message MUST use the same
 		//     value in the DHCP message header's 'secs' field and be sent to the
 		//     same IP broadcast address as the original DHCPDISCOVER message."
-		Secs: 0,
+		Secs: f.Secs,
 
 		Ciaddr: converter.IP2Array(net.IPv4zero), // 0.0.0.0
 		Chaddr: converter.Hardware2Array(netUtils.GetHardwareAddr(hardwareInterface.Name)),
@@ -144,3 +147,48 @@ func (f *DHCPPacketFactory) Decline(ack DHCPPacket) (*DHCPPacket, TxId) {
 
 	return &packet, tx
 }
+
+// Release builds DHCPRELEASE that gives leased address back to the server that granted it.
+// https://datatracker.ietf.org/doc/html/rfc2131#section-4.4.6
+func (f *DHCPPacketFactory) Release(lease DHCPLease) (*DHCPPacket, TxId) {
+	tx := RandomTransactionId()
+	config := f.Config
+
+	packet := DHCPPacket{
+		Op:     REQUEST,
+		Htype:  uint8(config.HardwareType),
+		Hlen:   uint8(config.HardwareAddrLen),
+		Xid:    tx,
+		Ciaddr: converter.IP2Array(lease.IpAddr),
+		Chaddr: converter.Hardware2Array(netUtils.GetHardwareAddr(hardwareInterface.Name)),
+	}
+
+	packet.AddOption(option.NewMessageTypeOpt(option.DHCPRELEASE))
+	packet.AddOption(option.NewServerIdentifierOpt(lease.ServerIdentifier))
+
+	return &packet, tx
+}
+
+// Inform builds DHCPINFORM the client with statically configured address uses to request local configuration
+// parameters. https://datatracker.ietf.org/doc/html/rfc2131#section-4.4.3
+func (f *DHCPPacketFactory) Inform(addr net.IP) (*DHCPPacket, TxId) {
+	tx := RandomTransactionId()
+	config := f.Config
+
+	packet := DHCPPacket{
+		Op:     REQUEST,
+		Htype:  uint8(config.HardwareType),
+		Hlen:   uint8(config.HardwareAddrLen),
+		Xid:    tx,
+		Secs:   f.Secs,
+		Ciaddr: converter.IP2Array(addr),
+		Chaddr: converter.Hardware2Array(netUtils.GetHardwareAddr(hardwareInterface.Name)),
+	}
+
+	packet.AddOption(option.NewMessageTypeOpt(option.DHCPINFORM))
+	packet.AddOption(option.NewParameterRequestListOpt(option.SUBNET_MASK, option.ROUTER_OPT,
+		option.DOMAIN_NAME_SERVER_OPT, option.DOMAIN_NAME, option.NET_TIME_PROTOCOL_SERVERS_OPT,
+		option.DOMAIN_SEARCH, option.STATIC_ROUTE_OPT, option.CLASSLESS_STATIC_ROUTE))
+
+	return &packet, tx
+}
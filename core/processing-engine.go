@@ -1,6 +1,9 @@
 package core
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/emirpasic/gods/lists"
 	"github.com/emirpasic/gods/lists/arraylist"
@@ -9,50 +12,94 @@ import (
 	"github.com/svishnyakoff/dhcpv4/packet"
 	"github.com/svishnyakoff/dhcpv4/packet/option"
 	"github.com/svishnyakoff/dhcpv4/transaction"
+	"github.com/svishnyakoff/dhcpv4/util/clock"
 	netUtils "github.com/svishnyakoff/dhcpv4/util/net-utils"
 	"github.com/svishnyakoff/dhcpv4/util/timers"
 	"log"
+	"math"
 	"net"
 	"os"
 	"sync"
 	"time"
 )
 
-type decodeError struct {
-	msg string
+type timeoutError struct{}
+
+func (e timeoutError) Error() string {
+	return "timeout waiting for dhcp packet"
+}
+
+func (e timeoutError) Timeout() bool {
+	return true
 }
 
-func (d decodeError) Error() string {
-	return d.msg
+type receivedPacket struct {
+	packet packet.DHCPPacket
+	err    error
 }
 
 type ProcessingEngine struct {
-	Client                 *UdpClient
+	Transport              Transport
+	AddressChecker         AddressChecker
+	OfferSelector          OfferSelector
+	GatewayResolver        GatewayResolver
+	Clock                  clock.Clock
 	Config                 configuration.DHCPConfig
 	Lease                  DHCPLease
+	LeaseStore             LeaseStore // lease is saved to the store every time client binds, renews or rebinds it
+	LeaseKey               LeaseKey
 	lock                   *sync.Mutex
 	terminate              chan int
 	stopped                bool
-	renewTimer             *time.Timer
-	rebindTimer            *time.Timer
+	packets                chan receivedPacket
+	linkChange             chan int // wakes processing loop up once LinkChanged is called
+	linkChanged            bool
+	expiry                 chan int // wakes processing loop up once expiry watchdog reports the lease as expired
+	leaseExpired           bool
+	expiredLease           DHCPLease // lease marked as expired, processing loop notifies listeners about it
+	watchedLease           DHCPLease // lease expiry watchdog waits to expire
+	publishedLease         DHCPLease // copy of Lease other goroutines read, it is updated once lease changes state
+	extendNow              chan int  // wakes processing loop up once Renew or Rebind is called
+	pendingExtension       *extension
+	done                   chan int  // closed once processing loop exits
+	acquisitionStart       time.Time // moment client began current address acquisition or renewal process
+	discoverSecs           uint16    // 'secs' of the last DHCPDISCOVER, following DHCPREQUEST must carry the same value
+	previousLease          DHCPLease // the last lease client held, it is used to prefer offer of the same address
+	gateway                gateway   // default gateway client found attached to, it is forgotten once link changes
+	discovery              *discovery
+	offerResults           []OfferResult
+	renewTimer             clock.Timer
+	rebindTimer            clock.Timer
+	expiryTimer            clock.Timer
 	leaseReceivedListeners []func(lease DHCPLease)
 	leaseRenewedListeners  []func(lease DHCPLease)
+	failureListeners       []func(err error)
+	eventListeners         []func(event Event)
 }
 
 type ProcessingEngineInitProps struct {
-	Client *UdpClient
-	Config *configuration.DHCPConfig
-	Lease  *DHCPLease
+	Transport      Transport
+	AddressChecker AddressChecker
+	OfferSelector  OfferSelector
+	// GatewayResolver is used to tell networks apart, so client reuses lease of the network it is attached to
+	GatewayResolver GatewayResolver
+	Clock           clock.Clock
+	Config          *configuration.DHCPConfig
+	Lease           *DHCPLease
+	// LeaseStore is where lease is saved to and loaded from. Defaults to INI files within DHCPConfig.LeaseDir
+	LeaseStore LeaseStore
+	// LeaseKey distinguishes lease of this client from leases of other clients sharing the same LeaseStore.
+	// Defaults to interface name from DHCPConfig and hardware address of the interface client sends packets through.
+	LeaseKey LeaseKey
 }
 
-func NewProcessingEngine(initProps ProcessingEngineInitProps) *ProcessingEngine {
-	if initProps.Client == nil {
-		initProps.Client = &UdpClient{}
-	}
+// AddressChecker reports whether IP address is not used by any other host on local network segment. By default
+// the check is made by ARP probe sent through the interface from DHCPConfig.
+type AddressChecker func(addr net.IP) bool
 
-	if initProps.Lease == nil {
-		l := LoadLease()
-		initProps.Lease = &l
+func NewProcessingEngine(initProps ProcessingEngineInitProps) *ProcessingEngine {
+	if initProps.Transport == nil {
+		initProps.Transport = &UdpClient{}
 	}
 
 	if initProps.Config == nil {
@@ -64,59 +111,233 @@ func NewProcessingEngine(initProps ProcessingEngineInitProps) *ProcessingEngine
 		initProps.Config = &conf
 	}
 
+	defaultStore := initProps.LeaseStore == nil
+	if defaultStore {
+		dir := initProps.Config.LeaseDir
+		if dir == "" {
+			dir = DefaultLeaseDir()
+		}
+
+		initProps.LeaseStore = NewIniLeaseStore(dir)
+	}
+
+	if initProps.LeaseKey == (LeaseKey{}) {
+		initProps.LeaseKey = LeaseKey{
+			InterfaceName:    initProps.Config.InterfaceName,
+			ClientIdentifier: hex.EncodeToString(interfaceHardwareAddr(initProps.Config.InterfaceName)),
+		}
+	}
+
+	if initProps.Lease == nil {
+		l, err := initProps.LeaseStore.Load(initProps.LeaseKey)
+		if err == ErrLeaseNotFound && defaultStore {
+			// lease saved by client version that kept single lease file
+			l, err = ImportLegacyLease(LegacyLeaseFile(), initProps.LeaseStore, initProps.LeaseKey)
+		}
+		if err != nil {
+			if err != ErrLeaseNotFound {
+				log.Println("failed to load saved lease", initProps.LeaseKey, err)
+			}
+			l = NewDHCPLease()
+		}
+		initProps.Lease = &l
+	}
+
+	if initProps.AddressChecker == nil {
+		config := *initProps.Config
+		initProps.AddressChecker = func(addr net.IP) bool {
+			return netUtils.IsUniqueIp(addr, config)
+		}
+	}
+
+	if initProps.GatewayResolver == nil {
+		config := *initProps.Config
+		initProps.GatewayResolver = func(gateway net.IP, sender net.IP) (net.HardwareAddr, error) {
+			return netUtils.ResolveHardwareAddr(gateway, sender, config)
+		}
+	}
+
+	if initProps.OfferSelector == nil {
+		initProps.OfferSelector = FirstOffer()
+	}
+
+	if initProps.Clock == nil {
+		initProps.Clock = clock.NewRealClock()
+	}
+
 	lock := &sync.Mutex{}
+	// expiry timer is armed once lease is bound
+	expiryTimer := initProps.Clock.NewTimer(9999 * time.Hour)
+	expiryTimer.Stop()
 
 	return &ProcessingEngine{
-		Client:      initProps.Client,
-		Config:      *initProps.Config,
-		Lease:       *initProps.Lease,
-		lock:        lock,
-		terminate:   make(chan int),
-		renewTimer:  time.NewTimer(9999 * time.Hour),
-		rebindTimer: time.NewTimer(9999 * time.Hour),
+		Transport:       initProps.Transport,
+		AddressChecker:  initProps.AddressChecker,
+		OfferSelector:   initProps.OfferSelector,
+		GatewayResolver: initProps.GatewayResolver,
+		Clock:           initProps.Clock,
+		Config:          *initProps.Config,
+		Lease:           *initProps.Lease,
+		publishedLease:  *initProps.Lease,
+		LeaseStore:      initProps.LeaseStore,
+		LeaseKey:        initProps.LeaseKey,
+		lock:            lock,
+		terminate:       make(chan int),
+		packets:         make(chan receivedPacket, 100),
+		linkChange:      make(chan int, 1),
+		expiry:          make(chan int, 1),
+		extendNow:       make(chan int, 1),
+		renewTimer:      initProps.Clock.NewTimer(9999 * time.Hour),
+		rebindTimer:     initProps.Clock.NewTimer(9999 * time.Hour),
+		expiryTimer:     expiryTimer,
 	}
 }
 
+// GetLease returns the lease as of the latest state change. Lease field itself is modified by processing loop without
+// synchronization, so it is not to be read while engine is running.
 func (p *ProcessingEngine) GetLease() DHCPLease {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	return p.Lease
+	return p.publishedLease
 }
 
+// Stop terminates processing engine. If ReleaseOnStop is set, leased address is given back to DHCP server.
 func (p *ProcessingEngine) Stop() {
+	if err := p.stop(p.Config.ReleaseOnStop); err != nil {
+		log.Println("could not release the lease:", err)
+	}
+}
+
+// Release gives leased address back to DHCP server that granted it and stops processing engine. The lease moves to
+// INIT state.
+func (p *ProcessingEngine) Release() error {
+	p.lock.Lock()
+	stopped, started := p.stopped, p.done != nil
+	p.lock.Unlock()
+
+	if stopped {
+		return fmt.Errorf("processing engine has been stopped")
+	}
+
+	// engine that has not been started has no lease to release, and stopping it would prevent it from starting
+	if !started {
+		return fmt.Errorf("processing engine has not been started")
+	}
+
+	return p.stop(true)
+}
+
+func (p *ProcessingEngine) stop(release bool) error {
+	p.lock.Lock()
 	if p.stopped {
-		return
+		p.lock.Unlock()
+		return nil
 	}
+	p.stopped = true
+	done := p.done
+	p.lock.Unlock()
 
 	log.Println("Terminating processing engine")
-	p.stopped = true
 	timers.SafeStop(p.renewTimer)
 	timers.SafeStop(p.rebindTimer)
-	p.Client.Stop()
+	timers.SafeStop(p.expiryTimer)
 	close(p.terminate)
+
+	var err error
+	if release {
+		// lease is modified by processing loop, so the loop has to exit before the lease is released
+		if done != nil {
+			<-done
+		}
+		err = p.release(done != nil)
+	}
+
+	p.Transport.Stop()
+	return err
+}
+
+func (p *ProcessingEngine) release(started bool) error {
+	lease := p.Lease
+
+	if !started {
+		return fmt.Errorf("processing engine has not been started")
+	}
+
+	if lease.ServerIdentifier == nil || lease.IsRebindPeriodExpired(p.Clock) {
+		return fmt.Errorf("client does not hold a lease, current state is %v", lease.State)
+	}
+
+	releasePacket, _ := p.packetFactory().Release(lease)
+	if err := p.Transport.Send(*releasePacket, lease.ServerIdentifier); err != nil {
+		return err
+	}
+
+	log.Println("released lease", lease.IpAddr)
+	// released lease must not be reused after restart
+	if err := p.LeaseStore.Delete(p.LeaseKey); err != nil {
+		log.Println("failed to delete released lease", p.LeaseKey, err)
+	}
+	// gateway is not probed here, lease is cached under network only if gateway was found once lease was bound
+	if network, err := p.networkOf(lease, false); err == nil {
+		if err := p.LeaseStore.Delete(p.LeaseKey.ForNetwork(network)); err != nil {
+			log.Println("failed to delete released lease of network", network, err)
+		}
+	}
+
+	p.emit(Event{Type: LeaseReleased})
+
+	from := p.setState(INIT)
+	p.emit(Event{Type: StateChanged, From: from, To: INIT})
+
+	return nil
+}
+
+func (p *ProcessingEngine) IsStopped() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.stopped
 }
 
 func (p *ProcessingEngine) Start() error {
-	client := p.Client
-	err := client.Listen()
+	err := p.Transport.Listen()
 
 	if err != nil {
-		return err
+		return &TransportError{Op: "listen", Err: err}
 	}
 
-	// todo The client SHOULD wait a random time between one and ten seconds to
-	//   desynchronize the use of DHCP at startup.
-	// https://datatracker.ietf.org/doc/html/rfc2131#page-34
+	go p.listen()
+	go p.watchExpiry()
+
 	p.normalizeStateAfterStart()
 	processInput := func() {
+		if p.reportLeaseExpiry() {
+			p.UpdateState(INIT)
+		}
+
+		if p.takeLinkChange() {
+			p.detectNetwork()
+		}
+
+		if p.Lease.State != BOUND {
+			// lease client was asked to renew or rebind is gone
+			p.finishExtension(fmt.Errorf("lease was lost in %v state: %w", p.Lease.State, ErrNotBound))
+		}
 
 		switch p.Lease.State {
 		case INIT:
-			p.Discover()
+			if p.isInformMode() {
+				p.Inform()
+			} else {
+				p.Discover()
+			}
+		case INFORMED:
+			// statically configured address does not need any maintenance
+			<-p.terminate
 		case BOUND:
-			renewTime := timers.SafeReset(p.renewTimer, p.Lease.DurationUntilRenew())
-			rebindTime := timers.SafeReset(p.rebindTimer, p.Lease.DurationUntilRebind())
+			renewTime := timers.SafeReset(p.renewTimer, p.Lease.DurationUntilRenew(p.Clock))
+			rebindTime := timers.SafeReset(p.rebindTimer, p.Lease.DurationUntilRebind(p.Clock))
 			log.Println("renew is scheduled in", renewTime)
 			log.Println("rebind is scheduled in", rebindTime)
 			p.RenewOrRebindLease()
@@ -127,7 +348,23 @@ func (p *ProcessingEngine) Start() error {
 		}
 	}
 
+	done := make(chan int)
+	p.lock.Lock()
+	p.done = done
+	p.lock.Unlock()
+
 	go func() {
+		defer close(done)
+
+		// gateway is probed by processing loop, so Start does not wait for ARP reply
+		if !p.isInformMode() {
+			p.detectNetwork()
+		}
+
+		if p.Lease.State == INIT {
+			p.waitStartupDelay()
+		}
+
 		for {
 			select {
 			case <-p.terminate:
@@ -143,53 +380,169 @@ func (p *ProcessingEngine) Start() error {
 	return nil
 }
 
-func (p *ProcessingEngine) Discover() {
-	c := p.Client
+// waitStartupDelay waits random time between StartupDelayMinSec and StartupDelayMaxSec before the first DHCPDISCOVER,
+// so hosts powered on at the same moment do not hit DHCP server all at once. Wait is interrupted by Stop.
+// https://datatracker.ietf.org/doc/html/rfc2131#page-34
+func (p *ProcessingEngine) waitStartupDelay() {
 	config := p.Config
-	maxRetries := 2
+	if config.StartupDelayMaxSec <= 0 {
+		return
+	}
 
-	data, tx := p.packetFactory().Discover()
+	delay := timers.RandomDuration(time.Duration(config.StartupDelayMinSec)*time.Second,
+		time.Duration(config.StartupDelayMaxSec)*time.Second)
+	log.Println("lease acquisition is delayed by", delay)
 
-	if err := c.Send(*data, net.IPv4bcast); err != nil {
-		// todo integration test - discover sent failed
+	timer := p.Clock.NewTimer(delay)
+	defer timer.Stop()
 
-		log.Println("send of discover command failed:", err)
+	select {
+	case <-timer.C():
+	case <-p.terminate:
+	}
+}
 
+func (p *ProcessingEngine) Discover() {
+	err := p.discover()
+	if errors.Is(err, ErrLinkChanged) {
+		// processing loop finds out which network host is attached to and starts over
+		log.Println(err)
+		p.UpdateState(INIT)
 		return
 	}
 
+	if err != nil {
+		log.Println(err)
+		p.UpdateState(INIT)
+		p.onFailure(err)
+		p.emit(Event{Type: AcquisitionFailed, Err: err})
+		p.onLeaseAcquisitionFailure()
+	}
+}
+
+// discovery is DHCPDISCOVER client keeps broadcasting until it binds. Rounds of waiting for offers share transaction
+// id and retransmission backoff, so DHCPDISCOVER is retransmitted at growing intervals up to RetransmitMaxSec rather
+// than starting over every MaxOfferWaitTimeSec.
+type discovery struct {
+	packet           packet.DHCPPacket
+	tx               transaction.TxId
+	backoff          *timers.Backoff
+	retransmitMoment time.Time // moment DHCPDISCOVER is sent next time unless offer is received before
+}
+
+// discover runs DHCPDISCOVER, DHCPOFFER, DHCPREQUEST, DHCPACK exchange once and reports why it failed
+func (p *ProcessingEngine) discover() error {
+	p.beginAcquisition()
+	if p.discovery == nil {
+		data, tx := p.packetFactory().Discover()
+		p.discovery = &discovery{packet: *data, tx: tx, backoff: p.retransmissionBackoff()}
+	}
+
 	p.UpdateState(SELECTING)
 
-	offers := p.readOffers(tx)
+	offers, err := p.readOffers(p.discovery)
+	if errors.Is(err, ErrLinkChanged) {
+		return err
+	}
+
+	receivedOffers := offers.Size()
+	offers = p.selectOffers(offers)
 
 	if offers.Size() > 0 {
-		var err error
-		i := 0
-		for ; i < maxRetries+1 && !offers.Empty(); i++ {
-			err = p.ProcessOffers(offers)
-			if err == nil {
-				return
-			}
+		if err := p.ProcessOffers(offers); err != nil {
+			return fmt.Errorf("none of %d offers was acknowledged by server: %w", offers.Size(), err)
+		}
+
+		return nil
+	}
 
-			log.Println("error processing offers: attempt", i+1, err)
-			waitSec(p.Config.RetryRequestSec)
+	if receivedOffers > 0 {
+		return fmt.Errorf("none of %d received offers is acceptable by offer selection policy: %w",
+			receivedOffers, ErrNoOffer)
+	}
+
+	if err != nil {
+		return fmt.Errorf("DHCP client did not receive any offer: %w", err)
+	}
+
+	return fmt.Errorf("DHCP client did not receive any offer during %d sec: %w", p.Config.MaxOfferWaitTimeSec,
+		ErrNoOffer)
+}
+
+// Acquire obtains lease once, the way `dhclient -1` does, instead of running processing loop. It runs DHCPDISCOVER,
+// DHCPOFFER, DHCPREQUEST, DHCPACK exchange, and stops processing engine once the exchange is over, so lease is
+// neither renewed nor rebound afterwards. Acquisition is aborted once context is done, context error is returned
+// then. Other errors wrap one of the errors from errors.go, such as ErrNoOffer or ErrNak.
+func (p *ProcessingEngine) Acquire(ctx context.Context) (DHCPLease, error) {
+	if err := ctx.Err(); err != nil {
+		return DHCPLease{}, err
+	}
+
+	p.lock.Lock()
+	if p.stopped || p.done != nil {
+		p.lock.Unlock()
+		return DHCPLease{}, fmt.Errorf("processing engine has been started or stopped already")
+	}
+	p.lock.Unlock()
+
+	if err := p.Transport.Listen(); err != nil {
+		return DHCPLease{}, &TransportError{Op: "listen", Err: err}
+	}
+	go p.listen()
+
+	finished := make(chan int)
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.stop(false)
+		case <-finished:
 		}
+	}()
+
+	defer p.stop(false)
+
+	err := p.discover()
+	if ctx.Err() != nil {
+		return DHCPLease{}, ctx.Err()
+	}
 
-		log.Printf("offer was not ack by server after %d attempts.\n", i)
+	if err != nil {
 		p.UpdateState(INIT)
-		p.onLeaseAcquisitionFailure()
-		return
+		p.onFailure(err)
+		p.emit(Event{Type: AcquisitionFailed, Err: err})
+		return DHCPLease{}, err
+	}
+
+	return p.GetLease(), nil
+}
+
+// selectOffers orders offers according to offer selection policy and leaves out offers the policy rejects
+func (p *ProcessingEngine) selectOffers(offers lists.List) lists.List {
+	received := make([]packet.DHCPPacket, 0, offers.Size())
+	for _, o := range offers.Values() {
+		received = append(received, o.(packet.DHCPPacket))
+	}
+
+	selected := arraylist.New()
+	for _, o := range p.OfferSelector.Select(received, p.previousLease) {
+		selected.Add(o)
 	}
 
-	log.Println("DHCP client did not receive any offer during time interval:", config.OfferWindowSec, "sec")
-	p.UpdateState(INIT)
-	p.onLeaseAcquisitionFailure()
+	return selected
 }
 
 func (p *ProcessingEngine) onLeaseAcquisitionFailure() {
 	if p.Config.StopOnLeaseAcquisitionFailure {
 		log.Println("stop further attempts to acquire lease due to StopOnLeaseAcquisitionFailure is set to true. ")
-		p.Stop()
+		// there is no lease to release, besides the processing loop cannot wait for itself to exit
+		p.stop(false)
+	}
+}
+
+func (p *ProcessingEngine) onFailure(err error) {
+	for _, listener := range p.failureListeners {
+		listener(err)
 	}
 }
 
@@ -205,69 +558,109 @@ func (p *ProcessingEngine) onLeaseRenewed() {
 	}
 }
 
+// ProcessOffers requests offered addresses one by one in the given order until server acknowledges one of them.
+// While server does not respond, the request is retransmitted according to retransmission policy, but once server
+// declines the request with DHCPNAK or acknowledged address turns out to be in use, the client moves on to the next
+// offer. Outcome of every requested offer is available through GetOfferResults.
 func (p *ProcessingEngine) ProcessOffers(offers lists.List) error {
+	results := make([]OfferResult, 0, offers.Size())
 
-	packetFactory := p.packetFactory()
-	offerPacket, _ := offers.Get(0)
-	serverIdentifier := offerPacket.(packet.DHCPPacket).GetOption(option.SERVER_IDENTIFIER)
+	var err error
+	for _, o := range offers.Values() {
+		if p.IsStopped() {
+			break
+		}
 
-	if serverIdentifier == nil {
-		return fmt.Errorf("DHCP server sent offer without server identifier")
+		offer := o.(packet.DHCPPacket)
+		var outcome OfferOutcome
+		outcome, err = p.requestOffer(offer)
+		if errors.Is(err, ErrLinkChanged) {
+			break
+		}
+
+		results = append(results, newOfferResult(offer, outcome, err))
+		if outcome == OfferAccepted {
+			break
+		}
+
+		log.Println("offer was not acknowledged, outcome:", outcome, err)
 	}
 
-	requestTime := time.Now()
-	requestPacket, reqTx := packetFactory.RequestForOffer(offerPacket.(packet.DHCPPacket))
-	if err := p.Client.Send(*requestPacket, net.IPv4bcast); err != nil {
-		log.Println("sending request on offer failed:", err)
+	p.lock.Lock()
+	p.offerResults = results
+	p.lock.Unlock()
 
-		return err
+	if len(results) > 0 && results[len(results)-1].Outcome == OfferAccepted {
+		p.UpdateState(BOUND)
+		p.onLeaseReceived()
+		p.emitPacketEvent(LeaseAcquired, p.Lease.Offer, nil)
+		return nil
 	}
 
-	p.UpdateState(REQUESTING)
+	if err == nil {
+		err = fmt.Errorf("processing engine has been stopped")
+	}
 
-	var waitForAck func() error
+	return err
+}
 
-	waitForAck = func() error {
-		ack, err := p.WaitForEvent(reqTx, time.Second)
+// GetOfferResults reports outcome of every offer the client requested during the latest address acquisition
+func (p *ProcessingEngine) GetOfferResults() []OfferResult {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 
-		if err != nil && os.IsTimeout(err) {
-			return fmt.Errorf("server did not respond to request within %v\n", time.Second)
-		}
+	return append([]OfferResult{}, p.offerResults...)
+}
 
-		if err != nil {
-			return fmt.Errorf("error while waiting for offer ack or nack packets: %v\n", err)
-		}
-		if !ack.IsPacketOfType(option.DHCPACK) && !ack.IsPacketOfType(option.DHCPNAK) {
-			log.Println("expected ACK or NACK but got", ack.GetMessageType(), "keep waiting for either ack or nack")
-			return waitForAck()
-		}
+func (p *ProcessingEngine) requestOffer(offer packet.DHCPPacket) (OfferOutcome, error) {
+	packetFactory := p.packetFactory()
+	packetFactory.Secs = p.discoverSecs
+	serverIdentifier := offer.GetOption(option.SERVER_IDENTIFIER)
 
-		if ack.IsPacketOfType(option.DHCPACK) {
-			if err = p.FinalizeOffer(&ack, requestTime); err != nil {
-				return err
-			}
-			p.UpdateState(BOUND)
-			p.onLeaseReceived()
-		}
+	if serverIdentifier == nil {
+		return OfferInvalid, fmt.Errorf("DHCP server sent offer without server identifier")
+	}
 
-		if ack.IsPacketOfType(option.DHCPNAK) {
-			return fmt.Errorf("server revoked the lease:%v\n", offerPacket.(packet.DHCPPacket).GetOption(option.SERVER_IDENTIFIER))
-		}
+	requestTime := p.Clock.Now()
+	requestPacket, reqTx := packetFactory.RequestForOffer(offer)
 
-		return nil
+	p.UpdateState(REQUESTING)
+
+	ack, err := p.exchange(*requestPacket, reqTx, net.IPv4bcast, p.Config.RetransmitMaxAttempts, time.Time{})
+
+	if err != nil && os.IsTimeout(err) {
+		return OfferTimedOut, requestError(err)
 	}
 
-	return waitForAck()
+	if err != nil {
+		return OfferFailed, requestError(err)
+	}
+
+	if ack.IsPacketOfType(option.DHCPNAK) {
+		err = newNakError(ack)
+		p.emitPacketEvent(NakReceived, ack, err)
+		return OfferNaked, err
+	}
+
+	if err = p.FinalizeOffer(&ack, requestTime); err != nil {
+		return OfferDeclined, err
+	}
+
+	return OfferAccepted, nil
 }
 
 func (p *ProcessingEngine) FinalizeOffer(ack *packet.DHCPPacket, requestTime time.Time) error {
-	if !netUtils.IsUniqueIp(ack.Yiaddr[:], p.Config) {
+	if !p.AddressChecker(ack.Yiaddr[:]) {
 		declinePacket, _ := p.packetFactory().Decline(*ack)
-		if err := p.Client.Send(*declinePacket, ack.GetOption(option.SERVER_IDENTIFIER).GetDataAsIP4()); err != nil {
+		if err := p.Transport.Send(*declinePacket, ack.GetOption(option.SERVER_IDENTIFIER).GetDataAsIP4()); err != nil {
 			log.Println("was not able to send DHCPDECLINE", err)
 		}
-		return fmt.Errorf("address offerred by DHCP server already present on local network segement: %v\n",
-			net.IP(ack.Yiaddr[:]))
+		err := &AddressConflictError{
+			Addr:             net.IP(ack.Yiaddr[:]),
+			ServerIdentifier: ack.GetOption(option.SERVER_IDENTIFIER).GetDataAsIP4(),
+		}
+		p.emitPacketEvent(ConflictDetected, *ack, err)
+		return err
 	}
 
 	p.Lease.Offer = *ack
@@ -275,7 +668,25 @@ func (p *ProcessingEngine) FinalizeOffer(ack *packet.DHCPPacket, requestTime tim
 	p.Lease.IpAddr = ack.Yiaddr[:]
 	p.Lease.LeaseDuration = ack.GetOption(option.IP_ADDR_LEASE_TIME).GetDataAsSecDuration()
 	p.Lease.LeaseInitTime = requestTime
+	p.applyConfigurationOptions(*ack)
+
+	if t1 := ack.GetOption(option.RENEWAL_TIME_VALUE); t1 != nil {
+		p.Lease.T1 = t1.GetDataAsSecDuration()
+	} else {
+		p.Lease.T1 = p.Lease.LeaseDuration / 2
+	}
 
+	if t2 := ack.GetOption(option.REBINDING_TIME_VALUE); t2 != nil {
+		p.Lease.T2 = t2.GetDataAsSecDuration()
+	} else {
+		p.Lease.T2 = time.Duration(float64(p.Lease.LeaseDuration) * 0.875)
+	}
+
+	return nil
+}
+
+// applyConfigurationOptions copies local configuration parameters from ACK to the lease
+func (p *ProcessingEngine) applyConfigurationOptions(ack packet.DHCPPacket) {
 	if subnet := ack.GetOption(option.SUBNET_MASK); subnet != nil {
 		p.Lease.SubnetMask = subnet.GetDataAsIpMask()
 	}
@@ -286,75 +697,165 @@ func (p *ProcessingEngine) FinalizeOffer(ack *packet.DHCPPacket, requestTime tim
 			p.Lease.Dns = dns[0]
 		}
 	}
+}
 
-	if t1 := ack.GetOption(option.RENEWAL_TIME_VALUE); t1 != nil {
-		p.Lease.T1 = t1.GetDataAsSecDuration()
-	} else {
-		p.Lease.T1 = p.Lease.LeaseDuration / 2
+// Inform obtains local configuration parameters for statically configured InformIpAddr address with DHCPINFORM.
+// Received parameters are exposed as a lease in INFORMED state, that has neither lease time nor renew and rebind
+// timers. https://datatracker.ietf.org/doc/html/rfc2131#section-3.4
+func (p *ProcessingEngine) Inform() {
+	addr := net.ParseIP(p.Config.InformIpAddr).To4()
+	if addr == nil {
+		log.Println("InformIpAddr is not valid IPv4 address:", p.Config.InformIpAddr)
+		p.onLeaseAcquisitionFailure()
+		p.sleep(time.Duration(p.Config.RetryRequestSec) * time.Second)
+		return
 	}
 
-	if t2 := ack.GetOption(option.REBINDING_TIME_VALUE); t2 != nil {
-		p.Lease.T2 = t2.GetDataAsSecDuration()
-	} else {
-		p.Lease.T2 = time.Duration(float64(p.Lease.LeaseDuration) * 0.875)
+	requestTime := p.Clock.Now()
+	p.beginAcquisition()
+	informPacket, tx := p.packetFactory().Inform(addr)
+
+	ack, err := p.exchange(*informPacket, tx, net.IPv4bcast, p.Config.RetransmitMaxAttempts, time.Time{})
+
+	if err == nil && !ack.IsPacketOfType(option.DHCPACK) {
+		err = newNakError(ack)
+		p.emitPacketEvent(NakReceived, ack, err)
 	}
 
-	return nil
+	if errors.Is(err, ErrLinkChanged) {
+		return
+	}
+
+	if err != nil {
+		log.Println("error obtaining configuration parameters with DHCPINFORM:", err)
+		if !p.IsStopped() {
+			p.onFailure(requestError(err))
+			p.emit(Event{Type: AcquisitionFailed, Err: requestError(err)})
+		}
+		p.onLeaseAcquisitionFailure()
+		p.sleep(time.Duration(p.Config.RetryRequestSec) * time.Second)
+		return
+	}
+
+	p.Lease.Offer = ack
+	p.Lease.IpAddr = addr
+	p.Lease.LeaseInitTime = requestTime
+	if serverIdentifier := ack.GetOption(option.SERVER_IDENTIFIER); serverIdentifier != nil {
+		p.Lease.ServerIdentifier = serverIdentifier.GetDataAsIP4()
+	}
+	p.applyConfigurationOptions(ack)
+
+	p.UpdateState(INFORMED)
+	p.onLeaseReceived()
+	p.emitPacketEvent(LeaseAcquired, ack, nil)
+}
+
+func (p *ProcessingEngine) isInformMode() bool {
+	return p.Config.InformIpAddr != ""
 }
 
 func (p *ProcessingEngine) UpdateState(newState State) {
-	log.Println("State change:", p.Lease.State, "->", newState)
+	from := p.setState(newState)
+
+	switch newState {
+	case INIT:
+		p.disarmExpiryWatchdog()
+	case BOUND:
+		p.armExpiryWatchdog()
+	}
+
+	p.emit(Event{Type: StateChanged, From: from, To: newState})
+}
+
+// setState moves the lease to new state without notifying event listeners nor arming expiry watchdog, and returns
+// the previous state
+func (p *ProcessingEngine) setState(newState State) State {
+	from := p.Lease.State
+	log.Println("State change:", from, "->", newState)
 	p.Lease.State = newState
 	switch newState {
 	case INIT:
+		if p.Lease.ServerIdentifier != nil {
+			p.previousLease = p.Lease
+		}
 		p.Lease.ResetLease()
+	case BOUND:
+		p.acquisitionStart = time.Time{}
+		p.discovery = nil
+		p.saveLease()
+		p.cacheLease()
+	case INFORMED:
+		p.acquisitionStart = time.Time{}
+	case RENEWING, REBINDING:
+		p.saveLease()
+	}
+
+	p.lock.Lock()
+	p.publishedLease = p.Lease
+	p.lock.Unlock()
+
+	return from
+}
+
+// saveLease persists current lease, so the client can reuse it in INIT-REBOOT state after restart
+func (p *ProcessingEngine) saveLease() {
+	if err := p.LeaseStore.Save(p.LeaseKey, p.Lease); err != nil {
+		log.Println("failed to save lease", p.LeaseKey, err)
 	}
 }
 
 func (p *ProcessingEngine) RenewAfterReboot() {
 	lease := p.Lease
-	packetFactory := p.packetFactory()
 
 	if lease.State != INIT_REBOOT {
 		log.Println("Cannot renew the lease from state", lease.State)
 		return
 	}
 
-	requestTime := time.Now()
-	requestPacket, tx := packetFactory.RequestForReboot(lease)
+	requestTime := p.Clock.Now()
+	p.beginAcquisition()
+	requestPacket, tx := p.packetFactory().RequestForReboot(lease)
 
-	if err := p.Client.Send(*requestPacket, net.IPv4bcast); err != nil {
-		log.Printf("request failed when tried to renew lease: %v\n", err)
-		p.UpdateState(INIT)
-		return
-	}
-
-	response, err := p.WaitForEvent(tx, time.Second)
+	response, err := p.exchange(*requestPacket, tx, net.IPv4bcast, p.Config.RetransmitMaxAttempts, time.Time{})
 	p.handleRenewResponse(response, requestTime, err)
 }
 
 func (p *ProcessingEngine) handleRenewResponse(response packet.DHCPPacket, requestTime time.Time, err error) {
+	if errors.Is(err, ErrLinkChanged) {
+		// lease is verified again once processing loop finds out which network host is attached to
+		return
+	}
+
 	if err != nil {
 		log.Println("error reading response for renew", err)
 		p.UpdateState(INIT)
+		if !p.IsStopped() {
+			p.onFailure(requestError(err))
+		}
 		return
 	}
 
 	if response.IsPacketOfType(option.DHCPNAK) {
 		log.Println("server declined to renew lease")
+		err := newNakError(response)
+		p.emitPacketEvent(NakReceived, response, err)
 		p.UpdateState(INIT)
+		p.onFailure(err)
 		return
 	} else if response.IsPacketOfType(option.DHCPACK) {
+		previous := p.Lease
 		if err := p.FinalizeOffer(&response, requestTime); err != nil {
 			log.Println("Probably BUG: it seems some other host within local network has the same IP address as"+
 				" the Lease's IP address we just renewed", err)
 			p.UpdateState(INIT)
+			p.onFailure(err)
 			return
 		}
 
 		log.Println("successfully renewed lease")
 		p.onLeaseRenewed()
 		p.UpdateState(BOUND)
+		p.emitExtendedEvent(LeaseRenewed, response, previous)
 		return
 	}
 }
@@ -363,137 +864,257 @@ func (p *ProcessingEngine) RenewOrRebindLease() {
 	var s State = 0
 	var renewed = false
 
-	if !p.Lease.IsRenewPeriodExpired() {
+	if !p.Lease.IsRenewPeriodExpired(p.Clock) {
 		s, renewed = p.RenewLease()
 	}
 
-	if renewed || s == INIT {
+	if renewed || s == INIT || p.isLinkChanged() || p.isLeaseExpired() {
 		return
 	}
 
-	if !p.Lease.IsRebindPeriodExpired() {
+	if !p.Lease.IsRebindPeriodExpired(p.Clock) {
 		p.RebindLease()
 	} else {
-		p.UpdateState(INIT)
+		p.expire()
 	}
 
 }
 
 func (p *ProcessingEngine) RenewLease() (State, bool) {
 	p.waitForTimer(p.renewTimer, p.Lease.GetRebindMoment())
-	if p.stopped || p.Lease.IsRenewPeriodExpired() {
+	if p.IsStopped() || p.isLinkChanged() || p.isLeaseExpired() || p.Lease.IsRenewPeriodExpired(p.Clock) {
+		return p.Lease.State, false
+	}
+
+	if ext := p.peekExtension(); ext != nil && ext.rebind {
+		// Rebind was called, so renewal is skipped
 		return p.Lease.State, false
 	}
 
 	log.Println("renewing lease")
 	lease := p.Lease
-	leaseInitTime := p.Lease.LeaseInitTime
-	packetFactory := p.packetFactory()
-
-	renewExpireMoment := leaseInitTime.Add(lease.T2)
-	if time.Now().Before(renewExpireMoment) {
-		requestTime := time.Now()
-		requestPacket, tx := packetFactory.RequestForRenew(lease)
+	requestTime := p.Clock.Now()
+	p.beginAcquisition()
+	requestPacket, tx := p.packetFactory().RequestForRenew(lease)
 
-		// todo don't print timeout errors "network error: read udp [::]:68: i/o timeout"
-		if err := p.Client.Send(*requestPacket, lease.ServerIdentifier); err != nil {
-			log.Printf("request failed when tried to renew lease: %v\n", err)
-		}
+	p.UpdateState(RENEWING)
 
-		p.UpdateState(RENEWING)
+	// request is retransmitted to the server that leased the address until T2, then the client starts rebinding
+	response, err := p.exchange(*requestPacket, tx, lease.ServerIdentifier, 0, lease.GetRebindMoment())
 
-		response, err := p.WaitForEvent(tx, time.Second)
+	if err != nil {
+		log.Println("error reading response for renew", err)
+		p.finishExtension(requestError(err))
+		return p.Lease.State, false
+	}
 
-		if err != nil {
-			log.Println("error reading response for renew", err)
-			timers.SafeReset(p.renewTimer, p.Lease.DurationUntilRenew())
-			return p.RenewLease()
-		}
+	if response.IsPacketOfType(option.DHCPNAK) {
+		log.Println("server declined to renew lease")
+		err := newNakError(response)
+		p.emitPacketEvent(NakReceived, response, err)
+		p.UpdateState(INIT)
+		p.onFailure(err)
+		p.finishExtension(err)
+		return INIT, false
+	}
 
-		if response.IsPacketOfType(option.DHCPNAK) {
-			log.Println("server declined to renew lease")
-			p.UpdateState(INIT)
-			return INIT, false
-		} else if response.IsPacketOfType(option.DHCPACK) {
-			err := p.FinalizeOffer(&response, requestTime)
-			if err != nil {
-				log.Println("Probably BUG: it seems some other host within local network has the same IP address as"+
-					" the Lease's IP address we just renewed", err)
-				p.UpdateState(INIT)
-				return INIT, false
-			} else {
-				log.Println("successfully renewed lease")
-				p.UpdateState(BOUND)
-				p.onLeaseRenewed()
-				return BOUND, true
-			}
-		}
+	previous := p.Lease
+	if err := p.FinalizeOffer(&response, requestTime); err != nil {
+		log.Println("Probably BUG: it seems some other host within local network has the same IP address as"+
+			" the Lease's IP address we just renewed", err)
+		p.UpdateState(INIT)
+		p.onFailure(err)
+		p.finishExtension(err)
+		return INIT, false
 	}
 
-	return p.Lease.State, false
+	log.Println("successfully renewed lease")
+	p.UpdateState(BOUND)
+	p.onLeaseRenewed()
+	p.emitExtendedEvent(LeaseRenewed, response, previous)
+	p.finishExtension(nil)
+	return BOUND, true
 }
 
 func (p *ProcessingEngine) RebindLease() (State, bool) {
 	p.waitForTimer(p.rebindTimer, p.Lease.GetLeaseExpirationMoment())
-	if p.stopped || p.Lease.IsRebindPeriodExpired() {
+	if p.IsStopped() || p.isLinkChanged() || p.isLeaseExpired() || p.Lease.IsRebindPeriodExpired(p.Clock) {
 		return p.Lease.State, false
 	}
+
 	log.Println("rebinding lease")
 	lease := p.Lease
-	leaseInitTime := p.Lease.LeaseInitTime
-	packetFactory := p.packetFactory()
-	rebindExpireMoment := leaseInitTime.Add(lease.LeaseDuration)
+	requestTime := p.Clock.Now()
+	// rebinding continues renewal process unless renewal period was missed entirely
+	p.beginAcquisition()
+	requestPacket, tx := p.packetFactory().RequestForRebind(lease)
+
+	p.UpdateState(REBINDING)
 
-	for time.Now().Before(rebindExpireMoment) {
-		requestTime := time.Now()
-		requestPacket, tx := packetFactory.RequestForRebind(lease)
+	// request is broadcast to all servers until the lease expires
+	response, err := p.exchange(*requestPacket, tx, net.IPv4bcast, 0, lease.GetLeaseExpirationMoment())
 
-		if err := p.Client.Send(*requestPacket, net.IPv4bcast); err != nil {
-			log.Printf("request failed when tried to rebind lease: %v\n", err)
+	if err != nil {
+		log.Println("error reading response for rebind", err)
+		if !p.Clock.Now().Before(lease.GetLeaseExpirationMoment()) {
+			// watchdog may not have noticed the expiry yet
+			p.expire()
 		}
+		p.finishExtension(requestError(err))
+		return p.Lease.State, false
+	}
 
-		p.UpdateState(REBINDING)
+	if response.IsPacketOfType(option.DHCPNAK) {
+		log.Println("server declined to rebind lease")
+		err := newNakError(response)
+		p.emitPacketEvent(NakReceived, response, err)
+		p.UpdateState(INIT)
+		p.onFailure(err)
+		p.finishExtension(err)
+		return INIT, false
+	}
 
-		response, err := p.WaitForEvent(tx, time.Second)
+	previous := p.Lease
+	if err := p.FinalizeOffer(&response, requestTime); err != nil {
+		log.Println("Probably BUG: it seems some other host within local network has the same IP address as"+
+			" the Lease's IP address we just renewed", err)
+		p.UpdateState(INIT)
+		p.onFailure(err)
+		p.finishExtension(err)
+		return INIT, false
+	}
 
-		if err != nil {
-			log.Println("error reading response for rebind", err)
-			continue
+	log.Println("successfully rebind lease")
+	p.UpdateState(BOUND)
+	p.onLeaseRenewed()
+	p.emitExtendedEvent(LeaseRebound, response, previous)
+	p.finishExtension(nil)
+	return BOUND, true
+}
+
+// exchange sends DHCPREQUEST and waits for DHCPACK or DHCPNAK from server. The request is retransmitted according to
+// retransmission policy until response arrives, maxAttempts transmissions are made or deadline is reached. If request
+// names the server it is meant for, responses of other servers are ignored.
+// Zero maxAttempts and zero deadline mean no limit. The 'secs' field of every retransmission reflects the time
+// elapsed since the client began address acquisition or renewal process, except DHCPREQUEST in SELECTING state, that
+// keeps 'secs' of DHCPDISCOVER, see https://datatracker.ietf.org/doc/html/rfc2131#section-4.4.1
+func (p *ProcessingEngine) exchange(request packet.DHCPPacket, tx transaction.TxId, addr net.IP, maxAttempts int,
+	deadline time.Time) (packet.DHCPPacket, error) {
+	backoff := p.retransmissionBackoff()
+	var sendErr error
+	var server net.IP
+	if serverIdentifier := request.GetOption(option.SERVER_IDENTIFIER); serverIdentifier != nil {
+		server = serverIdentifier.GetDataAsIP4()
+	}
+
+	for attempt := 0; maxAttempts <= 0 || attempt < maxAttempts; attempt++ {
+		now := p.Clock.Now()
+		if p.IsStopped() || (!deadline.IsZero() && !now.Before(deadline)) {
+			break
 		}
 
-		if response.IsPacketOfType(option.DHCPNAK) {
-			log.Println("server declined to rebind lease")
-			p.UpdateState(INIT)
-			return INIT, false
-		} else if response.IsPacketOfType(option.DHCPACK) {
-			err := p.FinalizeOffer(&response, requestTime)
-			if err != nil {
-				log.Println("Probably BUG: it seems some other host within local network has the same IP address as"+
-					" the Lease's IP address we just renewed", err)
-				p.UpdateState(INIT)
-				return INIT, false
-			} else {
-				log.Println("successfully rebind lease")
-				p.UpdateState(BOUND)
-				p.onLeaseRenewed()
-				return BOUND, true
-			}
+		if attempt > 0 && p.Lease.State != REQUESTING {
+			request.Secs = p.elapsedSecs()
 		}
+		sendErr = p.Transport.Send(request, addr)
+		if sendErr != nil {
+			log.Printf("sending %v failed: %v\n", request.GetMessageType(), sendErr)
+		}
+
+		retransmitMoment := now.Add(backoff.Next())
+		if !deadline.IsZero() && deadline.Before(retransmitMoment) {
+			retransmitMoment = deadline
+		}
+
+		response, err := p.waitForAckOrNak(tx, server, retransmitMoment)
+		if err == nil || !os.IsTimeout(err) {
+			return response, err
+		}
+	}
+
+	if sendErr != nil {
+		return packet.DHCPPacket{}, &TransportError{Op: "send " + request.GetMessageType().String(), Err: sendErr}
 	}
 
-	return p.Lease.State, false
+	return packet.DHCPPacket{}, timeoutError{}
+}
+
+// requestError turns error returned by exchange into error that tells why request failed
+func requestError(err error) error {
+	if os.IsTimeout(err) {
+		return fmt.Errorf("no response to request: %w", ErrTimeout)
+	}
+
+	return err
+}
+
+// waitForAckOrNak waits for DHCPACK or DHCPNAK of given transaction. Unless server is nil, response of any other
+// server is skipped, such as late response to the request for offer client has given up already.
+func (p *ProcessingEngine) waitForAckOrNak(tx transaction.TxId, server net.IP, timeout time.Time) (packet.DHCPPacket,
+	error) {
+	response, err := p.WaitForEventUntil(tx, timeout)
+
+	if err != nil {
+		return response, err
+	}
+
+	if !response.IsPacketOfType(option.DHCPACK) && !response.IsPacketOfType(option.DHCPNAK) {
+		log.Println("expected ACK or NACK but got", response.GetMessageType(), "keep waiting for either ack or nack")
+		return p.waitForAckOrNak(tx, server, timeout)
+	}
+
+	serverIdentifier := response.GetOption(option.SERVER_IDENTIFIER)
+	if server != nil && serverIdentifier != nil && !serverIdentifier.GetDataAsIP4().Equal(server) {
+		log.Println("skipping", response.GetMessageType(), "of server", serverIdentifier.GetDataAsIP4(),
+			"while waiting for server", server)
+		return p.waitForAckOrNak(tx, server, timeout)
+	}
+
+	return response, nil
+}
+
+func (p *ProcessingEngine) retransmissionBackoff() *timers.Backoff {
+	config := p.Config
+
+	return timers.NewBackoff(time.Duration(config.RetransmitInitialSec)*time.Second,
+		time.Duration(config.RetransmitMaxSec)*time.Second,
+		time.Duration(config.RetransmitRandomizationSec)*time.Second)
 }
 
 func (p *ProcessingEngine) packetFactory() *DHCPPacketFactory {
-	return &DHCPPacketFactory{Config: p.Config}
+	return &DHCPPacketFactory{Config: p.Config, Secs: p.elapsedSecs()}
+}
+
+// beginAcquisition remembers the moment client began address acquisition or renewal process, once client leaves INIT
+// or BOUND state. The moment is kept until client binds, so 'secs' keeps growing while client falls back from
+// renewal to rebinding or from INIT-REBOOT to discovery.
+func (p *ProcessingEngine) beginAcquisition() {
+	if p.acquisitionStart.IsZero() {
+		p.acquisitionStart = p.Clock.Now()
+	}
+}
+
+// elapsedSecs calculates value of 'secs' field, that is seconds elapsed since client began address acquisition or
+// renewal process
+func (p *ProcessingEngine) elapsedSecs() uint16 {
+	if p.acquisitionStart.IsZero() {
+		return 0
+	}
+
+	return uint16(math.Min(p.Clock.Now().Sub(p.acquisitionStart).Seconds(), math.MaxUint16))
 }
 
 func (p *ProcessingEngine) WaitForEvent(tx transaction.TxId, timeout time.Duration) (packet.DHCPPacket, error) {
-	return p.WaitForEventUntil(tx, time.Now().Add(timeout))
+	return p.WaitForEventUntil(tx, p.Clock.Now().Add(timeout))
 }
 
 func (p *ProcessingEngine) WaitForEventUntil(tx transaction.TxId, timeout time.Time) (packet.DHCPPacket, error) {
 	data, err := p.readPacket(timeout)
+	if err != nil && errors.Is(err, ErrMalformedPacket) {
+		// malformed packet may come from any host on the segment, it must not break the exchange
+		log.Println("skipping received packet:", err)
+		return p.WaitForEventUntil(tx, timeout)
+	}
 
 	if err != nil {
 		return packet.DHCPPacket{}, err
@@ -514,90 +1135,207 @@ func (p *ProcessingEngine) AddLeaseRenewedListener(listener func(lease DHCPLease
 	p.leaseRenewedListeners = append(p.leaseRenewedListeners, listener)
 }
 
+// AddFailureListener registers listener notified when client fails to acquire, renew or rebind lease, or loses it.
+// Error passed to the listener wraps one of ErrNoOffer, ErrNak, ErrAddressConflict, ErrTimeout, ErrTransport or
+// ErrLeaseExpired. Malformed packets are logged and skipped, so they do not fail the client.
+func (p *ProcessingEngine) AddFailureListener(listener func(err error)) {
+	p.failureListeners = append(p.failureListeners, listener)
+}
+
+// readPacket waits for the next packet received by transport until timeout moment measured by engine clock. Wait is
+// interrupted by Stop, lease expiry and LinkChanged, ErrLinkChanged is returned in the latter case.
 func (p *ProcessingEngine) readPacket(timeout time.Time) (packet.DHCPPacket, error) {
-	buf := make([]byte, 2000)
-	client := p.Client
-	bytesRead := 0
-	var err error = nil
+	timer := p.Clock.NewTimer(timeout.Sub(p.Clock.Now()))
+	defer timer.Stop()
 
-	for bytesRead <= 0 && err == nil {
-		client.conn.SetReadDeadline(timeout)
-		bytesRead, _, err = client.conn.ReadFromUDP(buf)
+	for {
+		select {
+		case received := <-p.packets:
+			return received.packet, received.err
+		case <-timer.C():
+			return packet.DHCPPacket{}, timeoutError{}
+		case <-p.terminate:
+			return packet.DHCPPacket{}, fmt.Errorf("processing engine has been stopped")
+		case <-p.expiry:
+			return packet.DHCPPacket{}, ErrLeaseExpired
+		case <-p.linkChange:
+			// signal of the change that has been handled already
+			if p.isLinkChanged() {
+				return packet.DHCPPacket{}, ErrLinkChanged
+			}
+		}
 	}
+}
 
-	if err != nil {
-		return packet.DHCPPacket{}, err
-	}
+// listen reads packets from transport and passes them to readPacket until engine is stopped. Transport deadline is
+// real time based and only serves to check periodically whether engine is still running.
+func (p *ProcessingEngine) listen() {
+	buf := make([]byte, 2000)
 
-	data, err := packet.Decode(buf, bytesRead)
-	if err != nil {
-		return packet.DHCPPacket{}, decodeError{
-			msg: fmt.Sprintf("cannot decode dhcp packet: %v", err),
+	for !p.IsStopped() {
+		bytesRead, err := p.Transport.Receive(buf, time.Now().Add(time.Second))
+
+		if err != nil && os.IsTimeout(err) {
+			continue
 		}
-	}
 
-	log.Printf("<--%v\n%v\n\n", data.GetMessageType(), data)
+		if err != nil && p.IsStopped() {
+			return
+		}
 
-	return data, err
+		received := receivedPacket{}
+
+		if err != nil {
+			received.err = &TransportError{Op: "receive", Err: err}
+		} else {
+			received.packet, err = packet.Decode(buf, bytesRead)
+			if err != nil {
+				received.err = &MalformedPacketError{Err: err}
+			} else {
+				log.Printf("<--%v\n%v\n\n", received.packet.GetMessageType(), received.packet)
+			}
+		}
+
+		select {
+		case p.packets <- received:
+		case <-p.terminate:
+			return
+		}
+	}
 }
 
 func (p *ProcessingEngine) normalizeStateAfterStart() {
+	if p.isInformMode() {
+		p.UpdateState(INIT)
+		return
+	}
+
 	switch p.Lease.State {
+	case INFORMED:
+		p.UpdateState(INIT)
 	case INIT_REBOOT, BOUND, RENEWING, REBINDING, REBOOTING, SELECTING, REQUESTING:
 		p.UpdateState(INIT_REBOOT)
 	}
 }
 
-func (p *ProcessingEngine) waitForTimer(t *time.Timer, timeout time.Time) {
+// waitForTimer waits until timer fires or timeout is reached. Wait is interrupted by Stop, LinkChanged, lease expiry,
+// Renew and Rebind.
+func (p *ProcessingEngine) waitForTimer(t clock.Timer, timeout time.Time) {
+	if p.peekExtension() != nil {
+		return
+	}
+
+	timeoutTimer := p.Clock.NewTimer(timeout.Sub(p.Clock.Now()))
+	defer timeoutTimer.Stop()
+
+	for {
+		select {
+		case <-t.C():
+			return
+		case <-p.terminate:
+			return
+		case <-p.linkChange:
+			// signal of the change that has been handled already
+			if p.isLinkChanged() {
+				return
+			}
+		case <-p.expiry:
+			return
+		case <-timeoutTimer.C():
+			return
+		case <-p.extendNow:
+			// caller of Renew or Rebind might have given up already
+			if p.peekExtension() != nil {
+				return
+			}
+		}
+	}
+}
+
+// sleep pauses processing for given duration or until engine is stopped
+func (p *ProcessingEngine) sleep(d time.Duration) {
+	timer := p.Clock.NewTimer(d)
+	defer timer.Stop()
 
 	select {
-	case <-t.C:
+	case <-timer.C():
 	case <-p.terminate:
-	case <-time.After(timeout.Sub(time.Now())):
 	}
 }
 
-// readOffers waits for offer commands from server for up to MaxOfferWaitTimeSec seconds.
+// readOffers broadcasts DHCPDISCOVER and waits for offer commands from server for up to MaxOfferWaitTimeSec seconds.
+// Until first offer is received, DHCPDISCOVER is retransmitted according to retransmission policy. Retransmission
+// continues where the previous round left off, so the first DHCPDISCOVER of the round is sent once the interval
+// started by the previous round elapses.
 // The optimistic expectation of the method is that offers  will be received in OfferWindowSec interval.
 // If at least one offer received during  OfferWindowSec interval,
 // to total execution time will be OfferWindowSec and only offers received during this time interval will be returned.
 // If optimistic expectation fails, the method will wait for first offer for up to MaxOfferWaitTimeSec seconds.
-func (p *ProcessingEngine) readOffers(tx transaction.TxId) (offers lists.List) {
-	offers = arraylist.New()
+// Besides offers, it returns the reason no offer was received, if the reason is other than servers did not respond.
+func (p *ProcessingEngine) readOffers(d *discovery) (lists.List, error) {
+	offers := arraylist.New()
+	var sendErr error
 	config := p.Config
-	offerTimeoutMoment := time.Now().Add(time.Second * time.Duration(config.MaxOfferWaitTimeSec))
-	offerWindowEndMoment := time.Now().Add(time.Second * time.Duration(config.OfferWindowSec))
+	startTime := p.Clock.Now()
+	offerTimeoutMoment := startTime.Add(time.Second * time.Duration(config.MaxOfferWaitTimeSec))
+	offerWindowEndMoment := startTime.Add(time.Second * time.Duration(config.OfferWindowSec))
 
 	for {
-		if time.Now().After(offerTimeoutMoment) {
-			return
+		now := p.Clock.Now()
+		if now.After(offerTimeoutMoment) || p.IsStopped() {
+			return offers, sendErr
 		}
 
-		responsePacket, err := p.WaitForEventUntil(tx, offerTimeoutMoment)
+		if offers.Empty() && !now.Before(d.retransmitMoment) {
+			d.packet.Secs = p.elapsedSecs()
+			p.discoverSecs = d.packet.Secs
+			sendErr = nil
+			if err := p.Transport.Send(d.packet, net.IPv4bcast); err != nil {
+				log.Println("send of discover command failed:", err)
+				sendErr = &TransportError{Op: "send DHCPDISCOVER", Err: err}
+			}
+
+			d.retransmitMoment = now.Add(d.backoff.Next())
+		}
+
+		waitUntil := offerTimeoutMoment
+		if offers.Empty() && d.retransmitMoment.Before(waitUntil) {
+			waitUntil = d.retransmitMoment
+		}
+
+		responsePacket, err := p.WaitForEventUntil(d.tx, waitUntil)
 		if err != nil && os.IsTimeout(err) {
-			return
+			if offers.Empty() && waitUntil.Before(offerTimeoutMoment) {
+				continue
+			}
+
+			return offers, sendErr
 		}
 
 		if err == nil && responsePacket.IsPacketOfType(option.DHCPOFFER) {
 			offers.Add(responsePacket)
-			if time.Now().After(offerWindowEndMoment) {
-				return
+			if p.Clock.Now().After(offerWindowEndMoment) {
+				return offers, nil
 			}
 
 			offerTimeoutMoment = offerWindowEndMoment
 		} else if err != nil {
 			log.Println("error while reading offer packets.", err)
-			return
+			return offers, err
 		} else {
 			log.Println("have been waiting for offer but got", responsePacket.GetMessageType())
 		}
 	}
 }
 
-func wait(t time.Duration) {
-	time.Sleep(t)
-}
+// interfaceHardwareAddr provides hardware address of the interface with given name. Address of the preferred outbound
+// interface is used if there is no such interface.
+func interfaceHardwareAddr(name string) net.HardwareAddr {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		log.Println("could not find interface", name, "use", hardwareInterface.Name, "instead:", err)
+		return hardwareInterface.HardwareAddr
+	}
 
-func waitSec(t int) {
-	time.Sleep(time.Duration(t) * time.Second)
+	return iface.HardwareAddr
 }
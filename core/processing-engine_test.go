@@ -1,12 +1,17 @@
 package core
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/svishnyakoff/dhcpv4/lease"
+	"github.com/svishnyakoff/dhcpv4/util/clock"
 	"github.com/svishnyakoff/dhcpv4/util/converter"
 	netUtils "github.com/svishnyakoff/dhcpv4/util/net-utils"
 	"net"
-	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -18,9 +23,11 @@ import (
 )
 
 func TestHappyPathToGetLease(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
 	leaseReceiveListener := new(LeaseListener)
 	leaseRenewListener := new(LeaseListener)
-	server := test.NewDHCPServer(net.ParseIP("127.0.0.1"), 2024)
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
 
 	server.AddReply(packet.DHCPPacket{
 		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
@@ -33,15 +40,14 @@ func TestHappyPathToGetLease(t *testing.T) {
 		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
 
 	server.Listen()
-	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &config.GlobalDHCPConfig,
-	})
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
 	processingEngine.Start()
 
-	time.Sleep(time.Second * 5)
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
 
 	processingEngine.Stop()
 	server.Stop()
@@ -49,8 +55,8 @@ func TestHappyPathToGetLease(t *testing.T) {
 	serverReceivedPackets := server.ReadAllReceivedPackets()
 
 	assert.True(t, serverReceivedPackets[0].Flags != 0)
-	assert.Equal(t, 1, leaseReceiveListener.count)
-	assert.Equal(t, 0, leaseRenewListener.count)
+	assert.Equal(t, 1, leaseReceiveListener.Count())
+	assert.Equal(t, 0, leaseRenewListener.Count())
 
 	l := processingEngine.GetLease()
 	assertLease(t, LeaseExpectation{
@@ -61,36 +67,119 @@ func TestHappyPathToGetLease(t *testing.T) {
 	}, l)
 }
 
-func TestClientToRetryRequest(t *testing.T) {
-	os.Setenv("RetryRequestSec", "1")
-	os.Setenv("StopOnLeaseAcquisitionFailure", "true")
-
-	defer os.Unsetenv("RetryRequestSec")
-	defer os.Unsetenv("StopOnLeaseAcquisitionFailure")
-	server := test.NewDHCPServer(net.ParseIP("127.0.0.1"), 2024)
+// TestSaveLeaseOnceBound verifies that acquired lease together with ACK is saved to lease store and is picked up by
+// engine created afterwards
+func TestSaveLeaseOnceBound(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
 	leaseReceiveListener := new(LeaseListener)
-	leaseRenewListener := new(LeaseListener)
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
 
 	server.AddReply(packet.DHCPPacket{
 		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
 	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
 		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
 
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
 	server.Listen()
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	expectation := LeaseExpectation{
+		State:            lease.BOUND,
+		IpAddr:           net.ParseIP("127.0.0.2").To4(),
+		LeaseDuration:    time.Second * 200,
+		ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+	}
+
+	savedLease, err := processingEngine.LeaseStore.Load(processingEngine.LeaseKey)
+	assert.NoError(t, err)
+	assertLease(t, expectation, savedLease)
+	assert.True(t, savedLease.Offer.IsPacketOfType(option.DHCPACK))
+	assert.Equal(t, time.Second*200, savedLease.Offer.GetOption(option.IP_ADDR_LEASE_TIME).GetDataAsSecDuration())
+
+	restartedEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  &stubTransport{},
+		Config:     &config.GlobalDHCPConfig,
+		LeaseStore: processingEngine.LeaseStore,
+	})
+	assertLease(t, expectation, restartedEngine.GetLease())
+}
+
+// TestDefaultLeaseKeyUsesConfiguredInterface verifies that lease is saved under hardware address of the interface client
+// is configured for
+func TestDefaultLeaseKeyUsesConfiguredInterface(t *testing.T) {
+	t.Parallel()
+	interfaces, err := net.Interfaces()
+	assert.NoError(t, err)
+
+	var configured *net.Interface
+	for i := range interfaces {
+		if interfaces[i].Name != hardwareInterface.Name &&
+			interfaces[i].HardwareAddr.String() != hardwareInterface.HardwareAddr.String() {
+			configured = &interfaces[i]
+			break
+		}
+	}
+	if configured == nil {
+		t.Skip("no interface other than", hardwareInterface.Name)
+	}
+
 	conf, _ := config.LoadConfig()
+	conf.InterfaceName = configured.Name
 	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &conf,
+		Transport:  &stubTransport{},
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
 	})
+
+	assert.Equal(t, lease.LeaseKey{
+		InterfaceName:    configured.Name,
+		ClientIdentifier: hex.EncodeToString(configured.HardwareAddr),
+	}, processingEngine.LeaseKey)
+}
+
+func TestClientToRetryRequest(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	leaseReceiveListener := new(LeaseListener)
+	leaseRenewListener := new(LeaseListener)
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	conf.RetransmitInitialSec = 1
+	conf.RetransmitRandomizationSec = 0
+	conf.RetransmitMaxAttempts = 3
+	conf.StopOnLeaseAcquisitionFailure = true
+	processingEngine := newVirtualEngine(network, conf, nil)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
 	processingEngine.Start()
 
 	packetFactory := DHCPPacketFactory{Config: conf}
 
-	time.Sleep(time.Second * 9)
+	waitUntil(t, time.Second*15, func() bool {
+		return processingEngine.IsStopped()
+	})
 
-	processingEngine.Stop()
 	server.Stop()
 
 	serverReceivedPackets := server.ReadAllReceivedPackets()
@@ -103,8 +192,8 @@ func TestClientToRetryRequest(t *testing.T) {
 	requestPacket, _ := packetFactory.RequestForOffer(serverSentPackets[0])
 	requestPacket.Xid = serverReceivedPackets[1].Xid
 
-	assert.Equal(t, 0, leaseReceiveListener.count)
-	assert.Equal(t, 0, leaseRenewListener.count)
+	assert.Equal(t, 0, leaseReceiveListener.Count())
+	assert.Equal(t, 0, leaseRenewListener.Count())
 	assert.Equal(t, 4, len(serverReceivedPackets))
 
 	assert.ElementsMatch(t, []packet.DHCPPacket{
@@ -124,7 +213,9 @@ func TestClientToRetryRequest(t *testing.T) {
 // TestRenewLeaseAfterReboot verifies INIT_BOOT -> BOUND transition,
 // that is situation when lease was previously acquired and for example we restarted host
 func TestRenewLeaseAfterReboot(t *testing.T) {
-	server := test.NewDHCPServer(net.ParseIP("127.0.0.1"), 2024)
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
 	leaseReceiveListener := new(LeaseListener)
 	leaseRenewListener := new(LeaseListener)
 
@@ -144,18 +235,16 @@ func TestRenewLeaseAfterReboot(t *testing.T) {
 		T1:               50 * time.Second,
 		T2:               75 * time.Second,
 	}
-	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &conf,
-		Lease:  &lizz,
-	})
+	processingEngine := newVirtualEngine(network, conf, &lizz)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
 	processingEngine.Start()
 
 	packetFactory := DHCPPacketFactory{Config: conf}
 
-	time.Sleep(time.Second * 2)
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseRenewListener.Count() == 1
+	})
 
 	processingEngine.Stop()
 	server.Stop()
@@ -166,8 +255,8 @@ func TestRenewLeaseAfterReboot(t *testing.T) {
 	requestPacket, _ := packetFactory.RequestForReboot(lizz)
 	requestPacket.Xid = serverReceivedPackets[0].Xid
 
-	assert.Equal(t, 0, leaseReceiveListener.count)
-	assert.Equal(t, 1, leaseRenewListener.count)
+	assert.Equal(t, 0, leaseReceiveListener.Count())
+	assert.Equal(t, 1, leaseRenewListener.Count())
 
 	assert.Equal(t, 1, len(serverReceivedPackets))
 
@@ -184,7 +273,9 @@ func TestRenewLeaseAfterReboot(t *testing.T) {
 }
 
 func TestRenewAfterRebootFailedAndThenRequestNewLease(t *testing.T) {
-	server := test.NewDHCPServer(net.ParseIP("127.0.0.1"), 2024)
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
 	leaseReceiveListener := new(LeaseListener)
 	leaseRenewListener := new(LeaseListener)
 
@@ -212,18 +303,16 @@ func TestRenewAfterRebootFailedAndThenRequestNewLease(t *testing.T) {
 		T1:               50 * time.Second,
 		T2:               75 * time.Second,
 	}
-	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &conf,
-		Lease:  &lizz,
-	})
+	processingEngine := newVirtualEngine(network, conf, &lizz)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
 	processingEngine.Start()
 
 	packetFactory := DHCPPacketFactory{Config: conf}
 
-	time.Sleep(time.Second * 5)
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
 
 	processingEngine.Stop()
 	server.Stop()
@@ -234,8 +323,8 @@ func TestRenewAfterRebootFailedAndThenRequestNewLease(t *testing.T) {
 	requestPacket, _ := packetFactory.RequestForReboot(lizz)
 	requestPacket.Xid = serverReceivedPackets[0].Xid
 
-	assert.Equal(t, 1, leaseReceiveListener.count)
-	assert.Equal(t, 0, leaseRenewListener.count)
+	assert.Equal(t, 1, leaseReceiveListener.Count())
+	assert.Equal(t, 0, leaseRenewListener.Count())
 
 	assert.Equal(t, 3, len(serverReceivedPackets))
 	assert.Equal(t, option.DHCPREQUEST, serverReceivedPackets[0].GetMessageType())
@@ -249,11 +338,10 @@ func TestRenewAfterRebootFailedAndThenRequestNewLease(t *testing.T) {
 }
 
 func TestReceiveSeveralOffers(t *testing.T) {
-	os.Setenv("RetryRequestSec", "0")
-	defer os.Unsetenv("RetryRequestSec")
-
-	server1 := test.NewDHCPServer(net.ParseIP("127.0.0.1").To4(), 2024)
-	server2 := test.NewDHCPServer(net.ParseIP("127.0.0.2").To4(), 2024)
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server1 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1").To4())
+	server2 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.2").To4())
 
 	leaseReceiveListener := new(LeaseListener)
 	leaseRenewListener := new(LeaseListener)
@@ -278,17 +366,17 @@ func TestReceiveSeveralOffers(t *testing.T) {
 	server2.Listen()
 
 	dhcpConfig, _ := config.LoadConfig()
-	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &dhcpConfig,
-	})
+	dhcpConfig.RetryRequestSec = 0
+	processingEngine := newVirtualEngine(network, dhcpConfig, nil)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
 	processingEngine.Start()
 
 	packetFactory := DHCPPacketFactory{Config: dhcpConfig}
 
-	time.Sleep(time.Second * 5)
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1 && len(server2.ReceivedPackets) == 2
+	})
 
 	processingEngine.Stop()
 	server1.Stop()
@@ -309,8 +397,8 @@ func TestReceiveSeveralOffers(t *testing.T) {
 	requestPacket.Xid = server1ReceivedPackets[1].Xid
 
 	l := processingEngine.GetLease()
-	assert.Equal(t, 1, leaseReceiveListener.count)
-	assert.Equal(t, 0, leaseRenewListener.count)
+	assert.Equal(t, 1, leaseReceiveListener.Count())
+	assert.Equal(t, 0, leaseRenewListener.Count())
 	assert.Equal(t, lease.BOUND, l.State)
 
 	assert.ElementsMatch(t, []packet.DHCPPacket{
@@ -321,13 +409,191 @@ func TestReceiveSeveralOffers(t *testing.T) {
 
 	assert.ElementsMatch(t, []packet.DHCPPacket{
 		*discoverPacket, *requestPacket,
-	}, server1ReceivedPackets, "expected: %v\n, received: %v", []packet.DHCPPacket{
+	}, server2ReceivedPackets, "expected: %v\n, received: %v", []packet.DHCPPacket{
 		*discoverPacket, *requestPacket,
 	}, server2ReceivedPackets)
 }
 
+func TestSelectOfferWithLongestLease(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server1 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1").To4())
+	server2 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.2").To4())
+	leaseReceiveListener := new(LeaseListener)
+
+	server1.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.7").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	// second offer arrives later, but still within offer window
+	server2.AddReplyWithDelay(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.8").To4()),
+	}, time.Millisecond*200, option.NewIpAddrLeaseTime(600), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.2").To4()))
+
+	server2.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.8").To4()),
+	}, option.NewIpAddrLeaseTime(600), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.2").To4()))
+
+	server1.Listen()
+	server2.Listen()
+
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:      test.NewVirtualTransport(network),
+		AddressChecker: network.IsUniqueIp,
+		OfferSelector:  LongestLease(),
+		Config:         &conf,
+		LeaseStore:     lease.NewMemoryLeaseStore(),
+	})
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server1.Stop()
+	server2.Stop()
+
+	assertLease(t, LeaseExpectation{
+		State:            lease.BOUND,
+		IpAddr:           net.ParseIP("127.0.0.8").To4(),
+		LeaseDuration:    time.Second * 600,
+		ServerIdentifier: net.ParseIP("127.0.0.2").To4(),
+	}, processingEngine.GetLease())
+}
+
+// TestFallBackToNextOfferAfterNak verifies that client requests the next offer when server declines the request for
+// the first one, and records outcome of every requested offer
+func TestFallBackToNextOfferAfterNak(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server1 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1").To4())
+	server2 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.2").To4())
+	leaseReceiveListener := new(LeaseListener)
+
+	server1.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.7").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server1.AddReply(packet.DHCPPacket{}, option.NewMessageTypeOpt(option.DHCPNAK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server2.AddReplyWithDelay(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.8").To4()),
+	}, time.Millisecond*200, option.NewIpAddrLeaseTime(600), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.2").To4()))
+
+	// request for the first offer is broadcast, so the second server sees it too
+	server2.AddIgnoreToReply()
+
+	server2.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.8").To4()),
+	}, option.NewIpAddrLeaseTime(600), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.2").To4()))
+
+	server1.Listen()
+	server2.Listen()
+
+	conf, _ := config.LoadConfig()
+	conf.RetryRequestSec = 0
+	processingEngine := newVirtualEngine(network, conf, nil)
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server1.Stop()
+	server2.Stop()
+
+	assertLease(t, LeaseExpectation{
+		State:            lease.BOUND,
+		IpAddr:           net.ParseIP("127.0.0.8").To4(),
+		LeaseDuration:    time.Second * 600,
+		ServerIdentifier: net.ParseIP("127.0.0.2").To4(),
+	}, processingEngine.GetLease())
+
+	results := processingEngine.GetOfferResults()
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, OfferNaked, results[0].Outcome)
+	assert.Equal(t, net.ParseIP("127.0.0.1").To4(), results[0].ServerIdentifier)
+	assert.Error(t, results[0].Err)
+	assert.Equal(t, OfferAccepted, results[1].Outcome)
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), results[1].ServerIdentifier)
+	assert.NoError(t, results[1].Err)
+}
+
+// TestLateNakOfPreviousOfferIsIgnored verifies that DHCPNAK server sends once client gave the request for its offer
+// up is not taken for the response to the request for the next offer, which shares transaction id
+func TestLateNakOfPreviousOfferIsIgnored(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server1 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1").To4())
+	server2 := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.2").To4())
+	leaseReceiveListener := new(LeaseListener)
+
+	server1.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.7").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	// NAK arrives once client waits for the second server
+	server1.AddReplyWithDelay(packet.DHCPPacket{}, time.Millisecond*2500, option.NewMessageTypeOpt(option.DHCPNAK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+	server1.AddIgnoreToReply()
+
+	server2.AddReplyWithDelay(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.8").To4()),
+	}, time.Millisecond*200, option.NewIpAddrLeaseTime(600), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.2").To4()))
+	server2.AddIgnoreToReply()
+	server2.AddReplyWithDelay(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.8").To4()),
+	}, time.Second, option.NewIpAddrLeaseTime(600), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.2").To4()))
+
+	server1.Listen()
+	server2.Listen()
+
+	conf, _ := config.LoadConfig()
+	conf.RetransmitInitialSec = 2
+	conf.RetransmitRandomizationSec = 0
+	conf.RetransmitMaxAttempts = 1
+	processingEngine := newVirtualEngine(network, conf, nil)
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*10, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server1.Stop()
+	server2.Stop()
+
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), processingEngine.GetLease().ServerIdentifier)
+
+	results := processingEngine.GetOfferResults()
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, OfferTimedOut, results[0].Outcome)
+	assert.Equal(t, net.ParseIP("127.0.0.1").To4(), results[0].ServerIdentifier)
+	assert.Equal(t, OfferAccepted, results[1].Outcome)
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), results[1].ServerIdentifier)
+}
+
 func TestRenewingLease(t *testing.T) {
-	server := test.NewDHCPServer(net.ParseIP("127.0.0.1"), 2024)
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
 	leaseReceiveListener := new(LeaseListener)
 	leaseRenewListener := new(LeaseListener)
 
@@ -348,21 +614,22 @@ func TestRenewingLease(t *testing.T) {
 		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
 
 	server.Listen()
-	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &config.GlobalDHCPConfig,
-	})
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	events := new(EventListener)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
+	processingEngine.AddEventListener(events.listen)
 	processingEngine.Start()
 
-	time.Sleep(time.Second * 10)
+	waitUntil(t, time.Second*10, func() bool {
+		return leaseRenewListener.Count() == 1
+	})
 
 	processingEngine.Stop()
 	server.Stop()
 
-	assert.Equal(t, 1, leaseReceiveListener.count)
-	assert.Equal(t, 1, leaseRenewListener.count)
+	assert.Equal(t, 1, leaseReceiveListener.Count())
+	assert.Equal(t, 1, leaseRenewListener.Count())
 
 	serverReceivedPackets := server.ReadAllReceivedPackets()
 	assert.Equal(t, 3, len(serverReceivedPackets))
@@ -377,10 +644,23 @@ func TestRenewingLease(t *testing.T) {
 		LeaseDuration:    time.Second * 300,
 		ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
 	}, l)
+
+	diff := events.Of(LeaseRenewed)[0].Diff
+	assert.Equal(t, []lease.FieldChange{
+		{Name: "LeaseDuration", Old: 200 * time.Second, New: 300 * time.Second},
+		{Name: "T1", Old: 3 * time.Second, New: 150 * time.Second},
+		{Name: "T2", Old: 175 * time.Second, New: 262500 * time.Millisecond},
+	}, diff.Fields)
+	assert.Len(t, diff.Options, 2)
+	assert.Equal(t, option.IP_ADDR_LEASE_TIME, diff.Options[0].Type)
+	assert.Equal(t, option.RENEWAL_TIME_VALUE, diff.Options[1].Type)
+	assert.Nil(t, diff.Options[1].New)
 }
 
 func TestRebindLease(t *testing.T) {
-	server := test.NewDHCPServer(net.ParseIP("127.0.0.1"), 2024)
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
 	leaseReceiveListener := new(LeaseListener)
 	leaseRenewListener := new(LeaseListener)
 
@@ -406,28 +686,27 @@ func TestRebindLease(t *testing.T) {
 		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
 
 	server.Listen()
-	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &config.GlobalDHCPConfig,
-	})
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
 	processingEngine.Start()
 
-	time.Sleep(time.Second * 10)
+	waitUntil(t, time.Second*10, func() bool {
+		return leaseRenewListener.Count() == 1
+	})
 
 	processingEngine.Stop()
 	server.Stop()
 
-	assert.Equal(t, 1, leaseReceiveListener.count)
-	assert.Equal(t, 1, leaseRenewListener.count)
+	assert.Equal(t, 1, leaseReceiveListener.Count())
+	assert.Equal(t, 1, leaseRenewListener.Count())
 
 	serverReceivedPackets := server.ReadAllReceivedPackets()
 	assert.Equal(t, 4, len(serverReceivedPackets))
 	assert.Equal(t, option.DHCPDISCOVER, serverReceivedPackets[0].GetMessageType())
 	assert.Equal(t, option.DHCPREQUEST, serverReceivedPackets[1].GetMessageType())
-	assert.Equal(t, option.DHCPREQUEST, serverReceivedPackets[1].GetMessageType())
 	assert.Equal(t, option.DHCPREQUEST, serverReceivedPackets[2].GetMessageType())
+	assert.Equal(t, option.DHCPREQUEST, serverReceivedPackets[3].GetMessageType())
 
 	l := processingEngine.GetLease()
 	assertLease(t, LeaseExpectation{
@@ -439,12 +718,11 @@ func TestRebindLease(t *testing.T) {
 }
 
 func TestDelayedOffer(t *testing.T) {
-	os.Setenv("MaxOfferWaitTimeSec", "5")
-	defer os.Unsetenv("MaxOfferWaitTimeSec")
-
+	t.Parallel()
+	network := test.NewVirtualNetwork()
 	leaseReceiveListener := new(LeaseListener)
 	leaseRenewListener := new(LeaseListener)
-	server := test.NewDHCPServer(net.ParseIP("127.0.0.1"), 2024)
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
 
 	server.AddReplyWithDelay(packet.DHCPPacket{
 		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
@@ -458,15 +736,15 @@ func TestDelayedOffer(t *testing.T) {
 
 	server.Listen()
 	dhcpConfig, _ := config.LoadConfig()
-	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
-		Client: &UdpClient{serverPort: 2024, useMulticast: true},
-		Config: &dhcpConfig,
-	})
+	dhcpConfig.MaxOfferWaitTimeSec = 5
+	processingEngine := newVirtualEngine(network, dhcpConfig, nil)
 	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
 	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
 	processingEngine.Start()
 
-	time.Sleep(time.Second * 7)
+	waitUntil(t, time.Second*7, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
 
 	processingEngine.Stop()
 	server.Stop()
@@ -474,8 +752,8 @@ func TestDelayedOffer(t *testing.T) {
 	serverReceivedPackets := server.ReadAllReceivedPackets()
 
 	assert.True(t, serverReceivedPackets[0].Flags != 0)
-	assert.Equal(t, 1, leaseReceiveListener.count)
-	assert.Equal(t, 0, leaseRenewListener.count)
+	assert.Equal(t, 1, leaseReceiveListener.Count())
+	assert.Equal(t, 0, leaseRenewListener.Count())
 
 	l := processingEngine.GetLease()
 	assertLease(t, LeaseExpectation{
@@ -486,12 +764,1537 @@ func TestDelayedOffer(t *testing.T) {
 	}, l)
 }
 
-type LeaseListener struct {
-	count int
+func TestGetLeaseThroughRelay(t *testing.T) {
+	t.Parallel()
+	clientNetwork := test.NewVirtualNetwork()
+	serverNetwork := test.NewVirtualNetwork()
+	leaseReceiveListener := new(LeaseListener)
+	server := test.NewVirtualDHCPServer(serverNetwork, net.ParseIP("10.0.0.1"))
+	relay := test.NewRelay(clientNetwork, serverNetwork, net.ParseIP("192.168.0.1"), net.ParseIP("10.0.0.1"))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("192.168.0.10").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("10.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("192.168.0.10").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("10.0.0.1").To4()))
+
+	server.Listen()
+	relay.Start()
+	processingEngine := newVirtualEngine(clientNetwork, config.GlobalDHCPConfig, nil)
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	relay.Stop()
+	server.Stop()
+
+	serverReceivedPackets := server.ReadAllReceivedPackets()
+	assert.Equal(t, 2, len(serverReceivedPackets))
+	for _, p := range serverReceivedPackets {
+		assert.Equal(t, net.ParseIP("192.168.0.1").To4(), net.IP(p.Giaddr[:]))
+		assert.Equal(t, byte(1), p.Hops)
+	}
+
+	assertLease(t, LeaseExpectation{
+		State:            lease.BOUND,
+		IpAddr:           net.ParseIP("192.168.0.10").To4(),
+		LeaseDuration:    time.Second * 200,
+		ServerIdentifier: net.ParseIP("10.0.0.1").To4(),
+	}, processingEngine.GetLease())
 }
 
-func (l *LeaseListener) listen(dhcpLease lease.DHCPLease) {
-	l.count++
+func TestDeclineOfferWhenAddressIsTaken(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	leaseReceiveListener := new(LeaseListener)
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	network.ClaimAddress(net.ParseIP("127.0.0.2"))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	conf.RetryRequestSec = 0
+	conf.RetransmitInitialSec = 1
+	conf.RetransmitRandomizationSec = 0
+	conf.RetransmitMaxAttempts = 1
+	conf.StopOnLeaseAcquisitionFailure = true
+	processingEngine := newVirtualEngine(network, conf, nil)
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*10, func() bool {
+		return processingEngine.IsStopped()
+	})
+
+	server.Stop()
+
+	serverReceivedPackets := server.ReadAllReceivedPackets()
+	assert.Equal(t, 0, leaseReceiveListener.Count())
+	assert.Equal(t, option.DHCPDISCOVER, serverReceivedPackets[0].GetMessageType())
+	assert.Equal(t, option.DHCPREQUEST, serverReceivedPackets[1].GetMessageType())
+	// DHCPDECLINE is sent right after ACK for the address that is already in use
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(),
+		serverReceivedPackets[2].GetOption(option.REQUEST_IP_ADDR).GetDataAsIP4())
+	assert.Equal(t, lease.INIT, processingEngine.GetLease().State)
+}
+
+func TestDiscoverOverCustomTransport(t *testing.T) {
+	t.Parallel()
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	transport := &stubTransport{}
+	conf, _ := config.LoadConfig()
+	conf.MaxOfferWaitTimeSec = 1
+	conf.StartupDelayMaxSec = 0
+	conf.StopOnLeaseAcquisitionFailure = true
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Clock:      fakeClock,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+	processingEngine.Start()
+
+	// engine stops itself once no offer is received within MaxOfferWaitTimeSec
+	waitUntil(t, time.Second*5, func() bool {
+		if fakeClock.ActiveTimers() > 2 {
+			fakeClock.AdvanceToNextTimer()
+		}
+
+		return processingEngine.IsStopped()
+	})
+
+	sentPackets := transport.SentPackets()
+	assert.Equal(t, 1, len(sentPackets))
+	assert.Equal(t, option.DHCPDISCOVER, sentPackets[0].GetMessageType())
+	assert.Equal(t, lease.INIT, processingEngine.GetLease().State)
+}
+
+// TestLeaseLifecycleWithFakeClock simulates whole day of lease lifetime: renew at T1, rebind at T2 and
+// discovery of a new lease after expiration, while server stops answering right after lease is acquired.
+func TestLeaseLifecycleWithFakeClock(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	leaseRenewListener := new(LeaseListener)
+
+	const leaseSec, t1Sec, t2Sec = 86400, 86220, 86340
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(leaseSec), option.NewT1Opt(t1Sec), option.NewT2Opt(t2Sec),
+		option.NewMessageTypeOpt(option.DHCPACK), option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	transport := &recordingTransport{Transport: test.NewVirtualTransport(network), clock: fakeClock}
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:      transport,
+		AddressChecker: network.IsUniqueIp,
+		Clock:          fakeClock,
+		Config:         &conf,
+		LeaseStore:     lease.NewMemoryLeaseStore(),
+		Lease: &lease.DHCPLease{
+			State:            lease.BOUND,
+			IpAddr:           net.ParseIP("127.0.0.2").To4(),
+			ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+			LeaseInitTime:    fakeClock.Now(),
+			LeaseDuration:    leaseSec * time.Second,
+			T1:               t1Sec * time.Second,
+			T2:               t2Sec * time.Second,
+		},
+	})
+	failures := new(FailureListener)
+	events := new(EventListener)
+	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
+	processingEngine.AddFailureListener(failures.listen)
+	processingEngine.AddEventListener(events.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseRenewListener.Count() == 1
+	})
+	leaseInitTime := processingEngine.GetLease().LeaseInitTime
+
+	waitUntil(t, time.Second*10, func() bool {
+		fakeClock.AdvanceToNextTimer()
+		return transport.FirstSent(isDiscover) != nil
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	renew := transport.FirstSent(isRenew)
+	rebind := transport.FirstSent(isRebind)
+	discover := transport.FirstSent(isDiscover)
+
+	assert.NotNil(t, renew)
+	assert.NotNil(t, rebind)
+	assert.Equal(t, leaseInitTime.Add(t1Sec*time.Second), renew.at)
+	assert.False(t, rebind.at.Before(leaseInitTime.Add(t2Sec*time.Second)))
+	assert.True(t, rebind.at.Before(leaseInitTime.Add(leaseSec*time.Second)))
+	assert.False(t, discover.at.Before(leaseInitTime.Add(leaseSec*time.Second)))
+
+	var expired *LeaseExpiredError
+	assert.True(t, len(failures.Errors()) > 0)
+	assert.True(t, errors.As(failures.Errors()[0], &expired))
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), expired.Lease.IpAddr)
+
+	renewed := events.Of(LeaseRenewed)
+	expiredEvents := events.Of(LeaseExpired)
+	assert.Len(t, renewed, 1)
+	assert.True(t, renewed[0].Packet.IsPacketOfType(option.DHCPACK))
+	assert.Equal(t, lease.BOUND, renewed[0].Lease.State)
+	assert.Len(t, expiredEvents, 1)
+	assert.Nil(t, expiredEvents[0].Packet)
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), expiredEvents[0].Lease.IpAddr)
+	assert.True(t, errors.Is(expiredEvents[0].Err, ErrLeaseExpired))
+}
+
+// TestLeaseExpiresDuringRenewal verifies expiry watchdog reports the lease as expired at its expiration moment, even
+// though client keeps retransmitting DHCPREQUEST until T2 that is past the expiration, and client then discovers a new
+// lease
+func TestLeaseExpiresDuringRenewal(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	const leaseSec, t1Sec, t2Sec = 100, 50, 200
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(leaseSec), option.NewT1Opt(t1Sec), option.NewT2Opt(t2Sec),
+		option.NewMessageTypeOpt(option.DHCPACK), option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	conf.RetransmitRandomizationSec = 0
+	transport := &recordingTransport{Transport: test.NewVirtualTransport(network), clock: fakeClock}
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:      transport,
+		AddressChecker: network.IsUniqueIp,
+		Clock:          fakeClock,
+		Config:         &conf,
+		LeaseStore:     lease.NewMemoryLeaseStore(),
+		Lease: &lease.DHCPLease{
+			State:            lease.BOUND,
+			IpAddr:           net.ParseIP("127.0.0.2").To4(),
+			ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+			LeaseInitTime:    fakeClock.Now(),
+			LeaseDuration:    leaseSec * time.Second,
+		},
+	})
+	events := new(EventListener)
+	processingEngine.AddEventListener(events.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return len(events.Of(LeaseRenewed)) == 1
+	})
+	expiration := processingEngine.GetLease().GetLeaseExpirationMoment()
+
+	waitUntil(t, time.Second*10, func() bool {
+		fakeClock.AdvanceToNextTimer()
+		return transport.FirstSent(isDiscover) != nil
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	renews := transport.AllSent(isRenew)
+	discover := transport.FirstSent(isDiscover)
+	expired := events.Of(LeaseExpired)
+
+	assert.True(t, len(renews) > 1)
+	for _, renew := range renews {
+		assert.True(t, renew.at.Before(expiration), "renew is sent after expiration at %v", renew.at)
+	}
+	assert.False(t, discover.at.Before(expiration))
+	assert.Len(t, expired, 1)
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), expired[0].Lease.IpAddr)
+	assert.True(t, errors.Is(expired[0].Err, ErrLeaseExpired))
+
+	all := events.All()
+	for i, e := range all {
+		if e.Type == LeaseExpired {
+			assert.Equal(t, StateChanged, all[i+1].Type)
+			assert.Equal(t, lease.INIT, all[i+1].To)
+		}
+	}
+}
+
+// TestDiscoverRetransmission verifies DHCPDISCOVER is retransmitted with exponential backoff until
+// MaxOfferWaitTimeSec elapses, see https://datatracker.ietf.org/doc/html/rfc2131#section-4.1
+func TestDiscoverRetransmission(t *testing.T) {
+	t.Parallel()
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	transport := &recordingTransport{Transport: &stubTransport{}, clock: fakeClock}
+	conf, _ := config.LoadConfig()
+	conf.MaxOfferWaitTimeSec = 100
+	conf.RetransmitRandomizationSec = 0
+	conf.StartupDelayMaxSec = 0
+	conf.StopOnLeaseAcquisitionFailure = true
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Clock:      fakeClock,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+	startTime := fakeClock.Now()
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		// renew and rebind timers are always active, the third one is set while engine waits for offers
+		if fakeClock.ActiveTimers() > 2 {
+			fakeClock.AdvanceToNextTimer()
+		}
+
+		return processingEngine.IsStopped()
+	})
+
+	sent := transport.AllSent(isDiscover)
+	assert.Equal(t, 5, len(sent))
+
+	for i, expectedSecs := range []uint16{0, 4, 12, 28, 60} {
+		assert.Equal(t, startTime.Add(time.Duration(expectedSecs)*time.Second), sent[i].at)
+		assert.Equal(t, expectedSecs, sent[i].packet.Secs)
+		assert.Equal(t, sent[0].packet.Xid, sent[i].packet.Xid)
+	}
+
+	assert.Equal(t, lease.INIT, processingEngine.GetLease().State)
+}
+
+// TestDiscoverRetransmissionAcrossRounds verifies DHCPDISCOVER keeps backing off up to RetransmitMaxSec, rather than
+// starting over once MaxOfferWaitTimeSec elapses without offers, and keeps transaction id and 'secs' of acquisition
+func TestDiscoverRetransmissionAcrossRounds(t *testing.T) {
+	t.Parallel()
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	transport := &recordingTransport{Transport: &stubTransport{}, clock: fakeClock}
+	conf, _ := config.LoadConfig()
+	conf.MaxOfferWaitTimeSec = 10
+	conf.RetransmitInitialSec = 4
+	conf.RetransmitMaxSec = 64
+	conf.RetransmitRandomizationSec = 0
+	conf.StartupDelayMaxSec = 0
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Clock:      fakeClock,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+	startTime := fakeClock.Now()
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		// renew and rebind timers are always active, the third one is set while engine waits for offers
+		if fakeClock.ActiveTimers() > 2 {
+			fakeClock.AdvanceToNextTimer()
+		}
+
+		return len(transport.AllSent(isDiscover)) == 7
+	})
+	processingEngine.Stop()
+
+	sent := transport.AllSent(isDiscover)
+	for i, expectedSecs := range []uint16{0, 4, 12, 28, 60, 124, 188} {
+		assert.Equal(t, startTime.Add(time.Duration(expectedSecs)*time.Second), sent[i].at)
+		assert.Equal(t, expectedSecs, sent[i].packet.Secs)
+		assert.Equal(t, sent[0].packet.Xid, sent[i].packet.Xid)
+	}
+}
+
+// TestSecsCountsFromStartOfAcquisition verifies that DHCPDISCOVER sent once INIT-REBOOT failed carries seconds elapsed
+// since client began to verify its lease, see https://datatracker.ietf.org/doc/html/rfc2131#section-2
+func TestSecsCountsFromStartOfAcquisition(t *testing.T) {
+	t.Parallel()
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	transport := &recordingTransport{Transport: &stubTransport{}, clock: fakeClock}
+	conf, _ := config.LoadConfig()
+	conf.RetransmitRandomizationSec = 0
+	conf.StartupDelayMaxSec = 0
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Clock:      fakeClock,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+		Lease: &lease.DHCPLease{
+			State:            lease.BOUND,
+			IpAddr:           net.ParseIP("127.0.0.2").To4(),
+			ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+			LeaseInitTime:    fakeClock.Now(),
+			LeaseDuration:    time.Hour,
+		},
+	})
+	startTime := fakeClock.Now()
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		// renew and rebind timers are always active, the third one is set while engine waits for server
+		if fakeClock.ActiveTimers() > 2 {
+			fakeClock.AdvanceToNextTimer()
+		}
+
+		return len(transport.AllSent(isDiscover)) == 2
+	})
+	processingEngine.Stop()
+
+	for _, discover := range transport.AllSent(isDiscover) {
+		assert.Equal(t, uint16(discover.at.Sub(startTime).Seconds()), discover.packet.Secs)
+		assert.True(t, discover.packet.Secs > 0)
+	}
+}
+
+// TestRequestCarriesSecsOfDiscover verifies DHCPREQUEST in SELECTING state has the same 'secs' value as the preceding
+// DHCPDISCOVER, see https://datatracker.ietf.org/doc/html/rfc2131#section-3.1
+func TestRequestCarriesSecsOfDiscover(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	leaseReceiveListener := new(LeaseListener)
+
+	// first DHCPDISCOVER is lost
+	server.AddIgnoreToReply()
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	// first DHCPREQUEST is lost as well
+	server.AddIgnoreToReply()
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	conf.RetransmitRandomizationSec = 0
+	conf.StartupDelayMaxSec = 0
+	transport := &recordingTransport{Transport: test.NewVirtualTransport(network), clock: fakeClock}
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:      transport,
+		AddressChecker: network.IsUniqueIp,
+		Clock:          fakeClock,
+		Config:         &conf,
+		LeaseStore:     lease.NewMemoryLeaseStore(),
+	})
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	// renew and rebind timers are always active, the third one is set while engine waits for offers
+	waitUntil(t, time.Second*5, func() bool {
+		return fakeClock.ActiveTimers() > 2
+	})
+	fakeClock.AdvanceToNextTimer()
+
+	// retransmit DHCPREQUEST
+	isRequest := func(p sentPacket) bool {
+		return p.packet.IsPacketOfType(option.DHCPREQUEST)
+	}
+	waitUntil(t, time.Second*5, func() bool {
+		return len(transport.AllSent(isRequest)) == 1 && fakeClock.ActiveTimers() > 2
+	})
+	fakeClock.AdvanceToNextTimer()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	discovers := transport.AllSent(isDiscover)
+	requests := transport.AllSent(isRequest)
+
+	assert.Equal(t, 2, len(discovers))
+	assert.Equal(t, uint16(0), discovers[0].packet.Secs)
+	assert.Equal(t, uint16(4), discovers[1].packet.Secs)
+	assert.Equal(t, 2, len(requests))
+	assert.Equal(t, fakeClock.Now(), requests[1].at, "request is retransmitted")
+	assert.Equal(t, uint16(4), requests[0].packet.Secs)
+	assert.Equal(t, uint16(4), requests[1].packet.Secs)
+}
+
+func TestReleaseLease(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	leaseReceiveListener := new(LeaseListener)
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
+	events := new(EventListener)
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.AddEventListener(events.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	err := processingEngine.Release()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return len(server.ReceivedPackets) == 3
+	})
+	server.Stop()
+
+	serverReceivedPackets := server.ReadAllReceivedPackets()
+	release := serverReceivedPackets[2]
+
+	assert.NoError(t, err)
+	assert.True(t, processingEngine.IsStopped())
+	assert.Equal(t, option.DHCPRELEASE, release.GetMessageType())
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), net.IP(release.Ciaddr[:]))
+	assert.Equal(t, net.ParseIP("127.0.0.1").To4(), release.GetOption(option.SERVER_IDENTIFIER).GetDataAsIP4())
+	assert.Equal(t, lease.INIT, processingEngine.GetLease().State)
+
+	types := events.Types()
+	released := events.Of(LeaseReleased)
+	assert.Equal(t, []EventType{LeaseReleased, StateChanged}, types[len(types)-2:])
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), released[0].Lease.IpAddr)
+}
+
+func TestReleaseOnStop(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	leaseRenewListener := new(LeaseListener)
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	conf.ReleaseOnStop = true
+	transport := &recordingTransport{Transport: test.NewVirtualTransport(network), clock: clock.NewRealClock()}
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:      transport,
+		AddressChecker: network.IsUniqueIp,
+		Config:         &conf,
+		LeaseStore:     lease.NewMemoryLeaseStore(),
+		Lease: &lease.DHCPLease{
+			State:            lease.BOUND,
+			IpAddr:           net.ParseIP("127.0.0.2").To4(),
+			ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+			LeaseInitTime:    time.Now(),
+			LeaseDuration:    100 * time.Second,
+			T1:               50 * time.Second,
+			T2:               75 * time.Second,
+		},
+	})
+	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseRenewListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	release := transport.FirstSent(func(p sentPacket) bool {
+		return p.packet.IsPacketOfType(option.DHCPRELEASE)
+	})
+
+	assert.NotNil(t, release)
+	// DHCPRELEASE is unicast to the server that granted the lease
+	assert.Equal(t, net.ParseIP("127.0.0.1").To4(), release.addr)
+	assert.Equal(t, lease.INIT, processingEngine.GetLease().State)
+}
+
+func TestReleaseWithoutLease(t *testing.T) {
+	t.Parallel()
+	transport := &stubTransport{}
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+	processingEngine.Start()
+
+	err := processingEngine.Release()
+
+	assert.Error(t, err)
+	assert.True(t, processingEngine.IsStopped())
+	for _, p := range transport.SentPackets() {
+		assert.NotEqual(t, option.DHCPRELEASE, p.GetMessageType())
+	}
+}
+
+func TestReleaseBeforeStart(t *testing.T) {
+	t.Parallel()
+	transport := &stubTransport{}
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+
+	err := processingEngine.Release()
+
+	assert.Error(t, err)
+	assert.False(t, processingEngine.IsStopped())
+
+	// engine still can be started
+	assert.NoError(t, processingEngine.Start())
+	waitUntil(t, time.Second*5, func() bool {
+		return len(transport.SentPackets()) > 0
+	})
+	processingEngine.Stop()
+	assert.Equal(t, option.DHCPDISCOVER, transport.SentPackets()[0].GetMessageType())
+}
+
+func TestInformForStaticAddress(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	leaseReceiveListener := new(LeaseListener)
+
+	server.AddReply(packet.DHCPPacket{},
+		option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()),
+		option.DHCPOption{Data: []byte{1, 4, 255, 255, 255, 0}, ID: option.SUBNET_MASK.String()},
+		option.DHCPOption{Data: []byte{6, 4, 127, 0, 0, 53}, ID: option.DOMAIN_NAME_SERVER_OPT.String()})
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	conf.InformIpAddr = "127.0.0.5"
+	processingEngine := newVirtualEngine(network, conf, nil)
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	serverReceivedPackets := server.ReadAllReceivedPackets()
+	assert.Equal(t, 1, len(serverReceivedPackets))
+	assert.Equal(t, option.DHCPINFORM, serverReceivedPackets[0].GetMessageType())
+	assert.Equal(t, net.ParseIP("127.0.0.5").To4(), net.IP(serverReceivedPackets[0].Ciaddr[:]))
+
+	l := processingEngine.GetLease()
+	assertLease(t, LeaseExpectation{
+		State:            lease.INFORMED,
+		IpAddr:           net.ParseIP("127.0.0.5").To4(),
+		Dns:              net.ParseIP("127.0.0.53").To4(),
+		SubnetMask:       net.IPv4Mask(255, 255, 255, 0),
+		ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+	}, l)
+	assert.Zero(t, l.T1)
+	assert.Zero(t, l.T2)
+}
+
+func TestDiscoverAfterStartupDelay(t *testing.T) {
+	t.Parallel()
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	transport := &recordingTransport{Transport: &stubTransport{}, clock: fakeClock}
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMinSec = 7
+	conf.StartupDelayMaxSec = 7
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Clock:      fakeClock,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+	startTime := fakeClock.Now()
+	processingEngine.Start()
+
+	// renew and rebind timers are always active, the third one is startup delay
+	waitUntil(t, time.Second*5, func() bool {
+		return fakeClock.ActiveTimers() > 2
+	})
+	assert.Nil(t, transport.FirstSent(isDiscover))
+
+	fakeClock.AdvanceToNextTimer()
+	waitUntil(t, time.Second*5, func() bool {
+		return transport.FirstSent(isDiscover) != nil
+	})
+	processingEngine.Stop()
+
+	assert.Equal(t, startTime.Add(7*time.Second), transport.FirstSent(isDiscover).at)
+}
+
+func TestStopDuringStartupDelay(t *testing.T) {
+	t.Parallel()
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	transport := &recordingTransport{Transport: &stubTransport{}, clock: fakeClock}
+	conf, _ := config.LoadConfig()
+
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  transport,
+		Clock:      fakeClock,
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return fakeClock.ActiveTimers() > 2
+	})
+	processingEngine.Stop()
+
+	// delay timer is stopped once engine leaves the wait
+	waitUntil(t, time.Second*5, func() bool {
+		return fakeClock.ActiveTimers() == 0
+	})
+	fakeClock.Advance(time.Minute)
+
+	assert.Nil(t, transport.FirstSent(isDiscover))
+}
+
+// newVirtualEngine creates engine attached to virtual network. Startup delay is disabled to save tests time.
+func newVirtualEngine(network *test.VirtualNetwork, conf config.DHCPConfig, l *lease.DHCPLease) *ProcessingEngine {
+	conf.StartupDelayMaxSec = 0
+
+	return NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:       test.NewVirtualTransport(network),
+		AddressChecker:  network.IsUniqueIp,
+		GatewayResolver: network.ResolveHardwareAddr,
+		Config:          &conf,
+		LeaseStore:      lease.NewMemoryLeaseStore(),
+		Lease:           l,
+	})
+}
+
+// waitUntil polls condition until it is met or fails the test once timeout is reached
+func waitUntil(t *testing.T, timeout time.Duration, condition func() bool) {
+	require.Eventually(t, condition, timeout, 10*time.Millisecond, "condition was not met within %v", timeout)
+}
+
+// stubTransport records packets sent by engine and never receives anything back
+type stubTransport struct {
+	lock    sync.Mutex
+	sent    []packet.DHCPPacket
+	stop    chan struct{}
+	stopped bool
+}
+
+func (s *stubTransport) Listen() error {
+	return nil
+}
+
+func (s *stubTransport) Send(p packet.DHCPPacket, addr net.IP) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sent = append(s.sent, p)
+	return nil
+}
+
+// Receive blocks until deadline, or until transport is stopped
+func (s *stubTransport) Receive(buf []byte, deadline time.Time) (int, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-s.stopChan():
+		return 0, errors.New("transport is stopped")
+	case <-timer.C:
+		return 0, timeoutError{}
+	}
+}
+
+func (s *stubTransport) Stop() {
+	stop := s.stopChan()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.stopped {
+		s.stopped = true
+		close(stop)
+	}
+}
+
+func (s *stubTransport) stopChan() chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+	}
+
+	return s.stop
+}
+
+func (s *stubTransport) SentPackets() []packet.DHCPPacket {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]packet.DHCPPacket{}, s.sent...)
+}
+
+type sentPacket struct {
+	packet packet.DHCPPacket
+	addr   net.IP
+	at     time.Time
+}
+
+// TestRebootWithLeaseOfAttachedNetwork verifies that client picks cached lease of the network whose gateway responds
+// rather than the last lease it held
+func TestRebootWithLeaseOfAttachedNetwork(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	leaseRenewListener := new(LeaseListener)
+	officeGateway, _ := net.ParseMAC("52:54:00:00:00:01")
+	homeGateway, _ := net.ParseMAC("52:54:00:00:00:02")
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	network.AddHost(net.ParseIP("192.168.1.254"), homeGateway)
+
+	key := lease.LeaseKey{InterfaceName: "eth0", ClientIdentifier: "client"}
+	office := networkLease("10.0.0.50", "10.0.0.1", "10.0.0.254", time.Now().Add(-time.Minute))
+	home := networkLease("127.0.0.2", "127.0.0.1", "192.168.1.254", time.Now().Add(-time.Hour))
+	store := lease.NewMemoryLeaseStore()
+	store.Save(key, office)
+	store.Save(key.ForNetwork(lease.NetworkKey(office.ServerIdentifier, officeGateway)), office)
+	store.Save(key.ForNetwork(lease.NetworkKey(home.ServerIdentifier, homeGateway)), home)
+
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:       test.NewVirtualTransport(network),
+		AddressChecker:  network.IsUniqueIp,
+		GatewayResolver: network.ResolveHardwareAddr,
+		Config:          &conf,
+		LeaseStore:      store,
+		LeaseKey:        key,
+	})
+	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseRenewListener.Count() == 1
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	serverReceivedPackets := server.ReadAllReceivedPackets()
+	assert.Equal(t, option.DHCPREQUEST, serverReceivedPackets[0].GetMessageType())
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(),
+		serverReceivedPackets[0].GetOption(option.REQUEST_IP_ADDR).GetDataAsIP4())
+	assertLease(t, LeaseExpectation{
+		State:            lease.BOUND,
+		IpAddr:           net.ParseIP("127.0.0.2").To4(),
+		LeaseDuration:    time.Second * 200,
+		ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+	}, processingEngine.GetLease())
+}
+
+// TestDiscoverWhenNoKnownNetworkIsAttached verifies that client discovers new lease if none of cached networks
+// responds
+func TestDiscoverWhenNoKnownNetworkIsAttached(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	transport := &recordingTransport{Transport: &stubTransport{}, clock: clock.NewRealClock()}
+	officeGateway, _ := net.ParseMAC("52:54:00:00:00:01")
+
+	key := lease.LeaseKey{InterfaceName: "eth0", ClientIdentifier: "client"}
+	office := networkLease("10.0.0.50", "10.0.0.1", "10.0.0.254", time.Now().Add(-time.Minute))
+	store := lease.NewMemoryLeaseStore()
+	store.Save(key, office)
+	store.Save(key.ForNetwork(lease.NetworkKey(office.ServerIdentifier, officeGateway)), office)
+
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:       transport,
+		GatewayResolver: network.ResolveHardwareAddr,
+		Config:          &conf,
+		LeaseStore:      store,
+		LeaseKey:        key,
+	})
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return transport.FirstSent(isDiscover) != nil
+	})
+	processingEngine.Stop()
+
+	assert.Equal(t, option.DHCPDISCOVER, transport.FirstSent(func(p sentPacket) bool { return true }).packet.GetMessageType())
+}
+
+// TestKeepLeaseWhenGatewayCannotBeProbed verifies that client reuses its lease in INIT-REBOOT state if ARP request
+// could not be sent, and that gateway is probed on behalf of the cached lease address
+func TestKeepLeaseWhenGatewayCannotBeProbed(t *testing.T) {
+	t.Parallel()
+	transport := &recordingTransport{Transport: &stubTransport{}, clock: clock.NewRealClock()}
+	officeGateway, _ := net.ParseMAC("52:54:00:00:00:01")
+	senders := make(chan net.IP, 10)
+	resolver := func(gateway net.IP, sender net.IP) (net.HardwareAddr, error) {
+		senders <- sender
+		return nil, errors.New("raw socket is not permitted")
+	}
+
+	key := lease.LeaseKey{InterfaceName: "eth0", ClientIdentifier: "client"}
+	office := networkLease("10.0.0.50", "10.0.0.1", "10.0.0.254", time.Now().Add(-time.Minute))
+	store := lease.NewMemoryLeaseStore()
+	store.Save(key, office)
+	store.Save(key.ForNetwork(lease.NetworkKey(office.ServerIdentifier, officeGateway)), office)
+
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:       transport,
+		GatewayResolver: resolver,
+		Config:          &conf,
+		LeaseStore:      store,
+		LeaseKey:        key,
+	})
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return transport.FirstSent(func(p sentPacket) bool { return true }) != nil
+	})
+	processingEngine.Stop()
+
+	first := transport.FirstSent(func(p sentPacket) bool { return true }).packet
+	assert.Equal(t, option.DHCPREQUEST, first.GetMessageType())
+	assert.Equal(t, office.IpAddr, first.GetOption(option.REQUEST_IP_ADDR).GetDataAsIP4())
+	assert.Equal(t, office.IpAddr, <-senders)
+}
+
+// TestLinkChangeDuringDiscover verifies that link change handled while client acquires a lease does not wake bound
+// client up, so the lease is renewed at T1 rather than right after it is bound
+func TestLinkChangeDuringDiscover(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	leaseReceiveListener := new(LeaseListener)
+
+	const leaseSec, t1Sec = 200, 100
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(leaseSec), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(leaseSec), option.NewT1Opt(t1Sec), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+	conf.OfferWindowSec = 0
+	transport := &recordingTransport{Transport: test.NewVirtualTransport(network), clock: fakeClock}
+	var processingEngine *ProcessingEngine
+	processingEngine = NewProcessingEngine(ProcessingEngineInitProps{
+		Transport: transport,
+		AddressChecker: func(addr net.IP) bool {
+			// link goes up while client checks acknowledged address
+			processingEngine.LinkChanged()
+			return network.IsUniqueIp(addr)
+		},
+		GatewayResolver: network.ResolveHardwareAddr,
+		Clock:           fakeClock,
+		Config:          &conf,
+		LeaseStore:      lease.NewMemoryLeaseStore(),
+	})
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+	leaseInitTime := processingEngine.GetLease().LeaseInitTime
+
+	waitUntil(t, time.Second*5, func() bool {
+		// renew and rebind timers are always active, the third one is set while engine waits for T1
+		if fakeClock.ActiveTimers() > 2 {
+			fakeClock.AdvanceToNextTimer()
+		}
+
+		return transport.FirstSent(isRenew) != nil
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	assert.Equal(t, leaseInitTime.Add(t1Sec*time.Second), transport.FirstSent(isRenew).at)
+}
+
+// TestLinkChangeDuringRenewal verifies that client stops retransmitting DHCPREQUEST to the server that granted the
+// lease once link changes, and verifies the lease in INIT-REBOOT state right away instead of waiting for T2
+func TestLinkChangeDuringRenewal(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	fakeClock := clock.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	leaseRenewListener := new(LeaseListener)
+
+	// server confirms the lease after reboot and stops answering afterwards
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(3600), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	conf, _ := config.LoadConfig()
+	transport := &recordingTransport{Transport: test.NewVirtualTransport(network), clock: fakeClock}
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:      transport,
+		AddressChecker: network.IsUniqueIp,
+		Clock:          fakeClock,
+		Config:         &conf,
+		LeaseStore:     lease.NewMemoryLeaseStore(),
+		Lease: &lease.DHCPLease{
+			State:            lease.BOUND,
+			IpAddr:           net.ParseIP("127.0.0.2").To4(),
+			ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+			LeaseInitTime:    fakeClock.Now(),
+			LeaseDuration:    time.Hour,
+		},
+	})
+	processingEngine.AddLeaseRenewedListener(leaseRenewListener.listen)
+	processingEngine.Start()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseRenewListener.Count() == 1
+	})
+
+	waitUntil(t, time.Second*5, func() bool {
+		// renew, rebind and expiry timers are always active, the fourth one is set while engine waits for T1
+		if fakeClock.ActiveTimers() > 3 {
+			fakeClock.AdvanceToNextTimer()
+		}
+
+		return transport.FirstSent(isRenew) != nil
+	})
+	renewedAt := fakeClock.Now()
+
+	processingEngine.LinkChanged()
+	isReboot := func(p sentPacket) bool {
+		return p.packet.IsPacketOfType(option.DHCPREQUEST) && p.packet.Ciaddr == [4]byte{} &&
+			!p.packet.HasOption(option.SERVER_IDENTIFIER)
+	}
+	waitUntil(t, time.Second*5, func() bool {
+		return len(transport.AllSent(isReboot)) == 2
+	})
+
+	processingEngine.Stop()
+	server.Stop()
+
+	reboot := transport.AllSent(isReboot)[1]
+	assert.Equal(t, renewedAt, reboot.at)
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), reboot.packet.GetOption(option.REQUEST_IP_ADDR).GetDataAsIP4())
+}
+
+func TestAcquireLease(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	defer server.Stop()
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
+	events := new(EventListener)
+	processingEngine.AddEventListener(events.listen)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := processingEngine.Acquire(ctx)
+
+	assert.NoError(t, err)
+	assert.True(t, processingEngine.IsStopped())
+	assert.Equal(t, []EventType{StateChanged, StateChanged, StateChanged, LeaseAcquired}, events.Types())
+	assert.Equal(t, lease.REQUESTING, events.Of(StateChanged)[2].From)
+	assert.Equal(t, lease.BOUND, events.Of(StateChanged)[2].To)
+	acquired := events.Of(LeaseAcquired)[0]
+	assert.True(t, acquired.Packet.IsPacketOfType(option.DHCPACK))
+	assert.Equal(t, l, acquired.Lease)
+	assertLease(t, LeaseExpectation{
+		State:            lease.BOUND,
+		IpAddr:           net.ParseIP("127.0.0.2").To4(),
+		LeaseDuration:    time.Second * 200,
+		ServerIdentifier: net.ParseIP("127.0.0.1").To4(),
+	}, l)
+}
+
+// TestMalformedPacketsAreSkipped verifies that packets that cannot be decoded, sent by any host on the network, do not
+// break waiting for offer and acknowledgement
+func TestMalformedPacketsAreSkipped(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	defer server.Stop()
+	conf := config.GlobalDHCPConfig
+	conf.StartupDelayMaxSec = 0
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:      &malformedPacketTransport{Transport: test.NewVirtualTransport(network)},
+		AddressChecker: network.IsUniqueIp,
+		Config:         &conf,
+		LeaseStore:     lease.NewMemoryLeaseStore(),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := processingEngine.Acquire(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, net.ParseIP("127.0.0.2").To4(), l.IpAddr)
+}
+
+// malformedPacketTransport receives truncated packet before every packet transport receives
+type malformedPacketTransport struct {
+	Transport
+	lock      sync.Mutex
+	malformed bool
+}
+
+func (t *malformedPacketTransport) Receive(buf []byte, deadline time.Time) (int, error) {
+	t.lock.Lock()
+	t.malformed = !t.malformed
+	malformed := t.malformed
+	t.lock.Unlock()
+
+	if malformed {
+		return copy(buf, []byte{packet.REPLY, 1, 6, 0}), nil
+	}
+
+	return t.Transport.Receive(buf, deadline)
+}
+
+func TestAcquireFailsWithNak(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{}, option.NewMessageTypeOpt(option.DHCPNAK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()),
+		option.NewMessageOpt("requested address is not on this subnet"))
+
+	server.Listen()
+	defer server.Stop()
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
+	failures := new(FailureListener)
+	events := new(EventListener)
+	processingEngine.AddFailureListener(failures.listen)
+	processingEngine.AddEventListener(events.listen)
+
+	_, err := processingEngine.Acquire(context.Background())
+
+	var nak *NakError
+	assert.True(t, errors.Is(err, ErrNak), "unexpected error: %v", err)
+	assert.True(t, errors.As(err, &nak))
+	assert.Equal(t, "requested address is not on this subnet", nak.Message)
+	assert.Equal(t, net.ParseIP("127.0.0.1").To4(), nak.ServerIdentifier)
+	assert.Equal(t, lease.INIT, processingEngine.GetLease().State)
+	assert.Equal(t, []error{err}, failures.Errors())
+
+	naks := events.Of(NakReceived)
+	assert.Len(t, naks, 1)
+	assert.True(t, naks[0].Packet.IsPacketOfType(option.DHCPNAK))
+	assert.True(t, errors.Is(naks[0].Err, ErrNak))
+	assert.Len(t, events.Of(AcquisitionFailed), 1)
+	assert.Equal(t, err, events.Of(AcquisitionFailed)[0].Err)
+}
+
+func TestAcquireFailsWhenAddressIsTaken(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	network.ClaimAddress(net.ParseIP("127.0.0.2"))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	defer server.Stop()
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
+	events := new(EventListener)
+	processingEngine.AddEventListener(events.listen)
+
+	_, err := processingEngine.Acquire(context.Background())
+
+	assert.True(t, errors.Is(err, ErrAddressConflict), "unexpected error: %v", err)
+	conflicts := events.Of(ConflictDetected)
+	assert.Len(t, conflicts, 1)
+	assert.Equal(t, converter.IP2Array(net.ParseIP("127.0.0.2").To4()), conflicts[0].Packet.Yiaddr)
+}
+
+func TestAcquireFailsWithoutOffers(t *testing.T) {
+	t.Parallel()
+	conf, _ := config.LoadConfig()
+	conf.MaxOfferWaitTimeSec = 1
+	processingEngine := newVirtualEngine(test.NewVirtualNetwork(), conf, nil)
+
+	_, err := processingEngine.Acquire(context.Background())
+
+	assert.True(t, errors.Is(err, ErrNoOffer), "unexpected error: %v", err)
+}
+
+func TestAcquireHonorsContextDeadline(t *testing.T) {
+	t.Parallel()
+	processingEngine := newVirtualEngine(test.NewVirtualNetwork(), config.GlobalDHCPConfig, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	_, err := processingEngine.Acquire(ctx)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(startTime) < 2*time.Second)
+	assert.True(t, processingEngine.IsStopped())
+}
+
+func TestRenewOnRequest(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	leaseReceiveListener := new(LeaseListener)
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(300), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	defer server.Stop()
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
+	transport := &recordingTransport{Transport: processingEngine.Transport, clock: processingEngine.Clock}
+	processingEngine.Transport = transport
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+	defer processingEngine.Stop()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := processingEngine.Renew(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, lease.BOUND, l.State)
+	assert.Equal(t, 300*time.Second, l.LeaseDuration)
+	assert.Len(t, transport.AllSent(isRenew), 1)
+	assert.Empty(t, transport.AllSent(isRebind))
+}
+
+func TestRebindOnRequest(t *testing.T) {
+	t.Parallel()
+	network := test.NewVirtualNetwork()
+	server := test.NewVirtualDHCPServer(network, net.ParseIP("127.0.0.1"))
+	leaseReceiveListener := new(LeaseListener)
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPOFFER),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{
+		Yiaddr: converter.IP2Array(net.ParseIP("127.0.0.2").To4()),
+	}, option.NewIpAddrLeaseTime(200), option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.AddReply(packet.DHCPPacket{}, option.NewMessageTypeOpt(option.DHCPNAK),
+		option.NewServerIdentifierOpt(net.ParseIP("127.0.0.1").To4()))
+
+	server.Listen()
+	defer server.Stop()
+	processingEngine := newVirtualEngine(network, config.GlobalDHCPConfig, nil)
+	transport := &recordingTransport{Transport: processingEngine.Transport, clock: processingEngine.Clock}
+	processingEngine.Transport = transport
+	processingEngine.AddLeaseReceivedListener(leaseReceiveListener.listen)
+	processingEngine.Start()
+	defer processingEngine.Stop()
+
+	waitUntil(t, time.Second*5, func() bool {
+		return leaseReceiveListener.Count() == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := processingEngine.Rebind(ctx)
+
+	assert.True(t, errors.Is(err, ErrNak), "unexpected error: %v", err)
+	assert.Len(t, transport.AllSent(isRebind), 1)
+	assert.Empty(t, transport.AllSent(isRenew))
+}
+
+func TestRenewRequiresBoundLease(t *testing.T) {
+	t.Parallel()
+	conf, _ := config.LoadConfig()
+	conf.StartupDelayMaxSec = 0
+	processingEngine := NewProcessingEngine(ProcessingEngineInitProps{
+		Transport:  &stubTransport{},
+		Config:     &conf,
+		LeaseStore: lease.NewMemoryLeaseStore(),
+	})
+
+	_, err := processingEngine.Renew(context.Background())
+	assert.Error(t, err)
+
+	processingEngine.Start()
+	defer processingEngine.Stop()
+
+	_, err = processingEngine.Rebind(context.Background())
+	assert.True(t, errors.Is(err, ErrNotBound), "unexpected error: %v", err)
+}
+
+// recordingTransport remembers every packet sent through underlying transport along with the clock time of sending
+type recordingTransport struct {
+	Transport
+	clock clock.Clock
+	lock  sync.Mutex
+	sent  []sentPacket
+}
+
+func (r *recordingTransport) Send(p packet.DHCPPacket, addr net.IP) error {
+	r.lock.Lock()
+	r.sent = append(r.sent, sentPacket{packet: p, addr: addr, at: r.clock.Now()})
+	r.lock.Unlock()
+
+	return r.Transport.Send(p, addr)
+}
+
+func (r *recordingTransport) FirstSent(matches func(p sentPacket) bool) *sentPacket {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, p := range r.sent {
+		if matches(p) {
+			return &p
+		}
+	}
+
+	return nil
+}
+
+func (r *recordingTransport) AllSent(matches func(p sentPacket) bool) []sentPacket {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var sent []sentPacket
+	for _, p := range r.sent {
+		if matches(p) {
+			sent = append(sent, p)
+		}
+	}
+
+	return sent
+}
+
+func isDiscover(p sentPacket) bool {
+	return p.packet.IsPacketOfType(option.DHCPDISCOVER)
+}
+
+// isRenew matches DHCPREQUEST unicast to the server that leased the address
+func isRenew(p sentPacket) bool {
+	return p.packet.IsPacketOfType(option.DHCPREQUEST) && !p.addr.Equal(net.IPv4bcast)
+}
+
+// isRebind matches DHCPREQUEST broadcast by client that still owns the address
+func isRebind(p sentPacket) bool {
+	return p.packet.IsPacketOfType(option.DHCPREQUEST) && p.addr.Equal(net.IPv4bcast) && p.packet.Ciaddr != [4]byte{}
+}
+
+// networkLease builds lease whose ACK tells the default gateway of the network
+func networkLease(ip string, serverIdentifier string, gateway string, start time.Time) lease.DHCPLease {
+	ack := packet.DHCPPacket{Yiaddr: converter.IP2Array(net.ParseIP(ip).To4())}
+	ack.AddOption(option.NewMessageTypeOpt(option.DHCPACK))
+	ack.AddOption(option.NewServerIdentifierOpt(net.ParseIP(serverIdentifier).To4()))
+	ack.AddOption(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP(gateway)))
+
+	return lease.DHCPLease{
+		State:            lease.BOUND,
+		IpAddr:           net.ParseIP(ip).To4(),
+		ServerIdentifier: net.ParseIP(serverIdentifier).To4(),
+		LeaseInitTime:    start,
+		LeaseDuration:    100 * time.Second,
+		T1:               50 * time.Second,
+		T2:               75 * time.Second,
+		Offer:            ack,
+	}
+}
+
+// FailureListener collects errors engine passes to failure listeners
+type FailureListener struct {
+	lock   sync.Mutex
+	errors []error
+}
+
+func (l *FailureListener) listen(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.errors = append(l.errors, err)
+}
+
+func (l *FailureListener) Errors() []error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]error{}, l.errors...)
+}
+
+// EventListener collects events engine passes to event listeners
+type EventListener struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (l *EventListener) listen(event Event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.events = append(l.events, event)
+}
+
+func (l *EventListener) All() []Event {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]Event{}, l.events...)
+}
+
+func (l *EventListener) Types() []EventType {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	types := make([]EventType, 0, len(l.events))
+	for _, e := range l.events {
+		types = append(types, e.Type)
+	}
+
+	return types
+}
+
+func (l *EventListener) Of(eventType EventType) []Event {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	events := make([]Event, 0)
+	for _, e := range l.events {
+		if e.Type == eventType {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+type LeaseListener struct {
+	lock  sync.Mutex
+	count int
+}
+
+func (l *LeaseListener) listen(dhcpLease lease.DHCPLease) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.count++
+}
+
+func (l *LeaseListener) Count() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.count
 }
 
 func assertLease(t *testing.T, expectation LeaseExpectation, l lease.DHCPLease) {
@@ -0,0 +1,25 @@
+package core
+
+import (
+	. "github.com/svishnyakoff/dhcpv4/packet"
+	"net"
+	"time"
+)
+
+// Transport is a medium processing engine uses to exchange DHCP packets with servers. UdpClient is the default
+// implementation, that talks to servers through UDP socket bound to port 68.
+type Transport interface {
+	// Listen prepares transport for sending and receiving packets. It is called once when engine starts.
+	Listen() error
+
+	// Send delivers packet to given address. Broadcast address is used when packet is addressed to all servers on
+	// local network segment.
+	Send(packet DHCPPacket, addr net.IP) error
+
+	// Receive reads single packet into buf and returns number of bytes read. If no packet arrived before deadline,
+	// Receive returns an error that satisfies os.IsTimeout.
+	Receive(buf []byte, deadline time.Time) (int, error)
+
+	// Stop releases resources held by transport. Transport is not used after Stop is called.
+	Stop()
+}
@@ -1,6 +1,7 @@
 package dhcpv4
 
 import (
+	"context"
 	"fmt"
 	"github.com/svishnyakoff/dhcpv4/core"
 	"github.com/svishnyakoff/dhcpv4/lease"
@@ -24,10 +25,50 @@ func (c *DHCPClient) Start() error {
 	return nil
 }
 
+// Acquire obtains lease once without starting background processing, so the lease is not renewed afterwards.
+// It gives up once context is done. Use errors.Is with core.ErrNoOffer, core.ErrNak and other errors from core package
+// to tell why acquisition failed.
+func (c *DHCPClient) Acquire(ctx context.Context) (lease.DHCPLease, error) {
+	return c.engine.Acquire(ctx)
+}
+
+// Renew makes client renew the lease right away instead of waiting for T1, and returns the renewed lease. It fails with
+// core.ErrNotBound if client does not hold a lease in BOUND state.
+func (c *DHCPClient) Renew(ctx context.Context) (lease.DHCPLease, error) {
+	return c.engine.Renew(ctx)
+}
+
+// Rebind makes client rebind the lease with any server right away instead of waiting for T2, and returns the rebound
+// lease. It fails with core.ErrNotBound if client does not hold a lease in BOUND state.
+func (c *DHCPClient) Rebind(ctx context.Context) (lease.DHCPLease, error) {
+	return c.engine.Rebind(ctx)
+}
+
 func (c *DHCPClient) Stop() {
 	c.engine.Stop()
 }
 
+// Release gives leased address back to DHCP server and stops the client
+func (c *DHCPClient) Release() error {
+	if err := c.engine.Release(); err != nil {
+		return fmt.Errorf("error releasing lease: %v", err)
+	}
+
+	return nil
+}
+
+// LinkChanged tells client that host might have been attached to another network. Client then reuses cached lease
+// of the network it is attached to or discovers a new one.
+func (c *DHCPClient) LinkChanged() {
+	c.engine.LinkChanged()
+}
+
+// GetOfferResults reports which offers the client requested during the latest address acquisition and how servers
+// responded to the requests
+func (c *DHCPClient) GetOfferResults() []core.OfferResult {
+	return c.engine.GetOfferResults()
+}
+
 func (c *DHCPClient) OnLeaseReceived(listener func(l lease.DHCPLease)) {
 	c.engine.AddLeaseReceivedListener(listener)
 }
@@ -36,6 +77,18 @@ func (c *DHCPClient) OnLeaseRenewed(listener func(l lease.DHCPLease)) {
 	c.engine.AddLeaseRenewedListener(listener)
 }
 
+// OnFailure registers listener notified when client fails to acquire, renew or rebind lease, or loses it. Use errors.Is
+// and errors.As with errors from core package to tell the reason.
+func (c *DHCPClient) OnFailure(listener func(err error)) {
+	c.engine.AddFailureListener(listener)
+}
+
+// OnEvent registers listener notified about every event of client lifecycle, such as state change, lease expiry or
+// DHCPNAK. Event carries the packet that caused it and the snapshot of the lease.
+func (c *DHCPClient) OnEvent(listener func(event core.Event)) {
+	c.engine.AddEventListener(listener)
+}
+
 func NewDHCPClient(props ClientProps) *DHCPClient {
 	return &DHCPClient{engine: core.NewProcessingEngine(props.ProcessingEngineInitProps)}
 }
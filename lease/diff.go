@@ -0,0 +1,139 @@
+package lease
+
+import (
+	"bytes"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"net"
+	"sort"
+)
+
+// LeaseDiff describes what server changed when it extended the lease. Fields lists changed lease fields and header
+// fields of DHCPACK, Options lists options of DHCPACK that were added, removed or got another value.
+type LeaseDiff struct {
+	Fields  []FieldChange
+	Options []OptionChange
+}
+
+// FieldChange holds old and new value of lease field, such as "SubnetMask" or "T1", or of DHCPACK header field,
+// such as "Siaddr" or "Sname"
+type FieldChange struct {
+	Name string
+	Old  interface{}
+	New  interface{}
+}
+
+// OptionChange holds old and new value of DHCPACK option. Old is nil for added option and New is nil for removed one.
+type OptionChange struct {
+	Type option.OptionType
+	Old  *option.DHCPOption
+	New  *option.DHCPOption
+}
+
+// DiffLeases compares lease before and after renewal. Lease state and start time are not compared, as they change
+// every time lease is extended.
+func DiffLeases(old DHCPLease, new DHCPLease) LeaseDiff {
+	diff := LeaseDiff{}
+
+	fields := []FieldChange{
+		{"IpAddr", old.IpAddr, new.IpAddr},
+		{"SubnetMask", old.SubnetMask, new.SubnetMask},
+		{"Dns", old.Dns, new.Dns},
+		{"ServerIdentifier", old.ServerIdentifier, new.ServerIdentifier},
+		{"LeaseDuration", old.LeaseDuration, new.LeaseDuration},
+		{"T1", old.T1, new.T1},
+		{"T2", old.T2, new.T2},
+		{"Siaddr", old.Offer.Siaddr, new.Offer.Siaddr},
+		{"Giaddr", old.Offer.Giaddr, new.Offer.Giaddr},
+		{"Sname", nullTerminated(old.Offer.Sname[:]), nullTerminated(new.Offer.Sname[:])},
+		{"File", nullTerminated(old.Offer.File[:]), nullTerminated(new.Offer.File[:])},
+	}
+
+	for _, f := range fields {
+		if !equalValues(f.Old, f.New) {
+			diff.Fields = append(diff.Fields, f)
+		}
+	}
+
+	oldOptions := optionsByType(old.Offer)
+	newOptions := optionsByType(new.Offer)
+	types := make([]option.OptionType, 0, len(oldOptions)+len(newOptions))
+	for t := range oldOptions {
+		types = append(types, t)
+	}
+	for t := range newOptions {
+		if _, ok := oldOptions[t]; !ok {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	for _, t := range types {
+		oldOption, newOption := oldOptions[t], newOptions[t]
+		if oldOption != nil && newOption != nil && bytes.Equal(oldOption.Data, newOption.Data) {
+			continue
+		}
+
+		diff.Options = append(diff.Options, OptionChange{Type: t, Old: oldOption, New: newOption})
+	}
+
+	return diff
+}
+
+// IsEmpty tells whether renewal left the lease as it was
+func (d LeaseDiff) IsEmpty() bool {
+	return len(d.Fields) == 0 && len(d.Options) == 0
+}
+
+// FieldChanged tells whether field with given name changed
+func (d LeaseDiff) FieldChanged(name string) bool {
+	for _, f := range d.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OptionChanged tells whether option of given type was added, removed or changed
+func (d LeaseDiff) OptionChanged(optionType option.OptionType) bool {
+	for _, o := range d.Options {
+		if o.Type == optionType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func optionsByType(p packet.DHCPPacket) map[option.OptionType]*option.DHCPOption {
+	options := make(map[option.OptionType]*option.DHCPOption)
+
+	for _, o := range p.GetOptions() {
+		o := o
+		options[option.OptionType(o.Data[0])] = &o
+	}
+
+	return options
+}
+
+// equalValues compares field values, addresses are equal regardless of their 4 or 16 byte representation
+func equalValues(a interface{}, b interface{}) bool {
+	switch a := a.(type) {
+	case net.IP:
+		return a.Equal(b.(net.IP)) || len(a) == 0 && len(b.(net.IP)) == 0
+	case net.IPMask:
+		return bytes.Equal(a, b.(net.IPMask))
+	default:
+		return a == b
+	}
+}
+
+func nullTerminated(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+
+	return string(b)
+}
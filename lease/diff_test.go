@@ -0,0 +1,40 @@
+package lease
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiffLeases(t *testing.T) {
+	old := createLease()
+	renewed := createLease()
+	renewed.IpAddr = old.IpAddr.To16()
+	renewed.State = RENEWING
+	renewed.LeaseInitTime = old.LeaseInitTime.Add(time.Hour)
+	renewed.Dns = net.ParseIP("8.8.4.4").To4()
+	copy(renewed.Offer.Sname[:], "dhcp.local")
+	renewed.Offer.AddOption(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("192.168.0.1")))
+
+	diff := DiffLeases(old, renewed)
+
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, []FieldChange{
+		{Name: "Dns", Old: old.Dns, New: renewed.Dns},
+		{Name: "Sname", Old: "", New: "dhcp.local"},
+	}, diff.Fields)
+	assert.True(t, diff.FieldChanged("Dns"))
+	assert.False(t, diff.FieldChanged("IpAddr"))
+	assert.Len(t, diff.Options, 1)
+	assert.True(t, diff.OptionChanged(option.ROUTER_OPT))
+	assert.Nil(t, diff.Options[0].Old)
+	assert.Equal(t, net.ParseIP("192.168.0.1").To4(), diff.Options[0].New.GetDataAsIP4())
+}
+
+func TestDiffOfUnchangedLease(t *testing.T) {
+	l := createLease()
+
+	assert.True(t, DiffLeases(l, l).IsEmpty())
+}
@@ -0,0 +1,285 @@
+package lease
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-ini/ini"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileLeaseStore keeps every lease in its own file within directory. File name is made of the lease key, so clients
+// with different keys never overwrite each other's lease.
+type fileLeaseStore struct {
+	dir       string
+	extension string
+	encode    func(l DHCPLease) ([]byte, error)
+	decode    func(data []byte) (DHCPLease, error)
+}
+
+// NewIniLeaseStore creates store that saves leases to INI files within the directory
+func NewIniLeaseStore(dir string) LeaseStore {
+	return &fileLeaseStore{dir: dir, extension: ".ini", encode: encodeIni, decode: decodeIni}
+}
+
+// NewJsonLeaseStore creates store that saves leases to JSON files within the directory
+func NewJsonLeaseStore(dir string) LeaseStore {
+	return &fileLeaseStore{dir: dir, extension: ".json", encode: encodeJson, decode: decodeJson}
+}
+
+func (s *fileLeaseStore) Load(key LeaseKey) (DHCPLease, error) {
+	data, err := ioutil.ReadFile(s.path(key))
+
+	if os.IsNotExist(err) {
+		return DHCPLease{}, ErrLeaseNotFound
+	}
+
+	if err != nil {
+		return DHCPLease{}, err
+	}
+
+	return s.decode(data)
+}
+
+// Save writes lease to temporary file first, that then replaces the lease file, so reader never observes partially
+// written lease
+func (s *fileLeaseStore) Save(key LeaseKey, l DHCPLease) error {
+	data, err := s.encode(l)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *fileLeaseStore) Delete(key LeaseKey) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileLeaseStore) List() ([]LeaseKey, error) {
+	files, err := ioutil.ReadDir(s.dir)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]LeaseKey, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), s.extension) {
+			continue
+		}
+
+		key, err := ParseLeaseKey(strings.TrimSuffix(f.Name(), s.extension))
+		if err != nil {
+			log.Println("skip unrecognized file in lease directory:", f.Name())
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// ImportLegacyLease copies lease from INI file, that is returned by LegacyLeaseFile, into the store under the key, so
+// client keeps reusing the lease it saved before leases were kept in LeaseStore. The file is left in place, so it is
+// still available to older versions and to clients with other keys. ErrLeaseNotFound is returned if there is no such
+// file.
+func ImportLegacyLease(leaseFile string, store LeaseStore, key LeaseKey) (DHCPLease, error) {
+	data, err := ioutil.ReadFile(leaseFile)
+
+	if os.IsNotExist(err) {
+		return DHCPLease{}, ErrLeaseNotFound
+	}
+
+	if err != nil {
+		return DHCPLease{}, err
+	}
+
+	l, err := decodeIni(data)
+	if err != nil {
+		return DHCPLease{}, fmt.Errorf("malformed lease file %v: %w", leaseFile, err)
+	}
+
+	if err = store.Save(key, l); err != nil {
+		return DHCPLease{}, err
+	}
+
+	return l, nil
+}
+
+func (s *fileLeaseStore) path(key LeaseKey) string {
+	return filepath.Join(s.dir, key.String()+s.extension)
+}
+
+func encodeIni(l DHCPLease) ([]byte, error) {
+	cfg := ini.Empty()
+
+	cfg.Section("").Key("state").SetValue(l.State.String())
+	cfg.Section("").Key("ip").SetValue(ipString(l.IpAddr))
+	cfg.Section("").Key("dns").SetValue(ipString(l.Dns))
+	cfg.Section("").Key("subnet.mask").SetValue(hex.EncodeToString(l.SubnetMask))
+	cfg.Section("").Key("server.ip").SetValue(ipString(l.ServerIdentifier))
+	cfg.Section("timers").Key("lease.start").SetValue(l.LeaseInitTime.Format(time.RFC3339Nano))
+	cfg.Section("timers").Key("lease.duration").SetValue(l.LeaseDuration.String())
+	cfg.Section("timers").Key("T1").SetValue(l.T1.String())
+	cfg.Section("timers").Key("T2").SetValue(l.T2.String())
+
+	if ack := encodeAck(l.Offer); ack != nil {
+		cfg.Section("packet").Key("ack").SetValue(hex.EncodeToString(ack))
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := cfg.WriteTo(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeIni(data []byte) (DHCPLease, error) {
+	cfg, err := ini.Load(data)
+
+	if err != nil {
+		return DHCPLease{}, err
+	}
+
+	l := DHCPLease{}
+
+	l.State = Parse(cfg.Section("").Key("state").In("INIT", StringCandidates()))
+	l.IpAddr = net.ParseIP(cfg.Section("").Key("ip").String()).To4()
+	l.Dns = net.ParseIP(cfg.Section("").Key("dns").String()).To4()
+	if mask, _ := hex.DecodeString(cfg.Section("").Key("subnet.mask").String()); len(mask) > 0 {
+		l.SubnetMask = mask
+	}
+	l.ServerIdentifier = net.ParseIP(cfg.Section("").Key("server.ip").String()).To4()
+	l.LeaseInitTime = cfg.Section("timers").Key("lease.start").MustTime()
+	l.LeaseDuration = cfg.Section("timers").Key("lease.duration").MustDuration()
+	l.T1 = cfg.Section("timers").Key("T1").MustDuration()
+	l.T2 = cfg.Section("timers").Key("T2").MustDuration()
+
+	if ack, err := hex.DecodeString(cfg.Section("packet").Key("ack").String()); err == nil {
+		l.Offer = decodeAck(ack)
+	}
+
+	return l, nil
+}
+
+// jsonLease is JSON representation of DHCPLease. ACK is kept as raw packet, since DHCPPacket does not expose options
+// to JSON encoder.
+type jsonLease struct {
+	State            string        `json:"state"`
+	IpAddr           net.IP        `json:"ip,omitempty"`
+	Dns              net.IP        `json:"dns,omitempty"`
+	SubnetMask       net.IPMask    `json:"subnetMask,omitempty"`
+	ServerIdentifier net.IP        `json:"serverIp,omitempty"`
+	LeaseInitTime    time.Time     `json:"leaseStart"`
+	LeaseDuration    time.Duration `json:"leaseDuration"`
+	T1               time.Duration `json:"t1"`
+	T2               time.Duration `json:"t2"`
+	Ack              []byte        `json:"ack,omitempty"`
+}
+
+func encodeJson(l DHCPLease) ([]byte, error) {
+	return json.MarshalIndent(jsonLease{
+		State:            l.State.String(),
+		IpAddr:           l.IpAddr,
+		Dns:              l.Dns,
+		SubnetMask:       l.SubnetMask,
+		ServerIdentifier: l.ServerIdentifier,
+		LeaseInitTime:    l.LeaseInitTime,
+		LeaseDuration:    l.LeaseDuration,
+		T1:               l.T1,
+		T2:               l.T2,
+		Ack:              encodeAck(l.Offer),
+	}, "", "  ")
+}
+
+func decodeJson(data []byte) (DHCPLease, error) {
+	var j jsonLease
+
+	if err := json.Unmarshal(data, &j); err != nil {
+		return DHCPLease{}, err
+	}
+
+	return DHCPLease{
+		State:            Parse(j.State),
+		IpAddr:           j.IpAddr.To4(),
+		Dns:              j.Dns.To4(),
+		SubnetMask:       j.SubnetMask,
+		ServerIdentifier: j.ServerIdentifier.To4(),
+		LeaseInitTime:    j.LeaseInitTime,
+		LeaseDuration:    j.LeaseDuration,
+		T1:               j.T1,
+		T2:               j.T2,
+		Offer:            decodeAck(j.Ack),
+	}, nil
+}
+
+func encodeAck(ack packet.DHCPPacket) []byte {
+	if ack.GetMessageType() == option.UNKNOWN {
+		return nil
+	}
+
+	return ack.Encode()
+}
+
+func decodeAck(data []byte) packet.DHCPPacket {
+	if len(data) == 0 {
+		return packet.DHCPPacket{}
+	}
+
+	ack, err := packet.Decode(data, len(data))
+	if err != nil {
+		log.Println("saved lease contains malformed ACK packet:", err)
+	}
+
+	return ack
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
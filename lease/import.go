@@ -0,0 +1,356 @@
+package lease
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"github.com/svishnyakoff/dhcpv4/util/converter"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Importers of leases obtained by other DHCP clients. Imported lease is in INIT_REBOOT state, so the engine started
+// with it requests the same address instead of discovering a new one.
+
+// ImportDhclientLease reads the most recent lease for the interface from ISC dhclient leases file, such as
+// /var/lib/dhcp/dhclient.leases. Empty interface name matches lease of any interface.
+func ImportDhclientLease(path string, interfaceName string) (DHCPLease, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DHCPLease{}, err
+	}
+	defer file.Close()
+
+	var found *dhclientLease
+	var current *dhclientLease
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "lease") && strings.HasSuffix(line, "{"):
+			current = &dhclientLease{ack: newImportedAck()}
+		case line == "}":
+			if current != nil && (interfaceName == "" || current.interfaceName == interfaceName) {
+				found = current
+			}
+			current = nil
+		case current != nil:
+			if err := current.parseStatement(strings.TrimSuffix(line, ";")); err != nil {
+				return DHCPLease{}, fmt.Errorf("malformed dhclient lease %v: %v", path, err)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return DHCPLease{}, err
+	}
+
+	if found == nil || found.ack.Yiaddr == [4]byte{} {
+		return DHCPLease{}, fmt.Errorf("no lease for interface %q in %v", interfaceName, path)
+	}
+
+	start := found.expire
+	if found.expire.IsZero() {
+		// infinite lease never expires, so it is as good as acquired right now
+		start = time.Now()
+		if found.ack.GetOption(option.IP_ADDR_LEASE_TIME) == nil {
+			found.ack.AddOption(option.NewIpAddrLeaseTime(math.MaxUint32))
+		}
+	} else if leaseTime := found.ack.GetOption(option.IP_ADDR_LEASE_TIME); leaseTime != nil {
+		start = found.expire.Add(-leaseTime.GetDataAsSecDuration())
+	}
+
+	return leaseFromAck(found.ack, start), nil
+}
+
+// ImportDhcpcdLease reads dhcpcd lease file, such as /var/lib/dhcpcd/eth0.lease. The file holds raw DHCPACK, and its
+// modification time is the moment the lease was acknowledged.
+func ImportDhcpcdLease(path string) (DHCPLease, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return DHCPLease{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return DHCPLease{}, err
+	}
+
+	ack, err := decodeRawAck(data)
+	if err != nil {
+		return DHCPLease{}, fmt.Errorf("malformed dhcpcd lease %v: %v", path, err)
+	}
+
+	return leaseFromAck(ack, info.ModTime()), nil
+}
+
+// ImportNetworkdLease reads systemd-networkd lease file, such as /run/systemd/netif/leases/2. The file is rewritten
+// every time lease is acquired or renewed, so its modification time is taken as the lease start.
+func ImportNetworkdLease(path string) (DHCPLease, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DHCPLease{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return DHCPLease{}, err
+	}
+
+	ack := newImportedAck()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+
+		if err := parseNetworkdValue(&ack, keyValue[0], keyValue[1]); err != nil {
+			return DHCPLease{}, fmt.Errorf("malformed networkd lease %v: %v", path, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return DHCPLease{}, err
+	}
+
+	if ack.Yiaddr == [4]byte{} {
+		return DHCPLease{}, fmt.Errorf("networkd lease %v has no address", path)
+	}
+
+	return leaseFromAck(ack, info.ModTime()), nil
+}
+
+type dhclientLease struct {
+	interfaceName string
+	expire        time.Time
+	ack           packet.DHCPPacket
+}
+
+func (l *dhclientLease) parseStatement(statement string) error {
+	fields := strings.Fields(statement)
+	if len(fields) < 2 {
+		return nil
+	}
+
+	switch fields[0] {
+	case "interface":
+		l.interfaceName = strings.Trim(fields[1], "\"")
+	case "fixed-address":
+		ip, err := parseIPs(fields[1])
+		if err != nil {
+			return err
+		}
+		l.ack.Yiaddr = converter.IP2Array(ip[0])
+	case "expire":
+		expire, err := parseDhclientTime(fields[1:])
+		if err != nil {
+			return err
+		}
+		l.expire = expire
+	case "option":
+		if len(fields) < 3 {
+			return nil
+		}
+		return addDhclientOption(&l.ack, fields[1], strings.Join(fields[2:], ""))
+	}
+
+	return nil
+}
+
+func addDhclientOption(ack *packet.DHCPPacket, name string, value string) error {
+	ipOptions := map[string]option.OptionType{
+		"subnet-mask":            option.SUBNET_MASK,
+		"routers":                option.ROUTER_OPT,
+		"domain-name-servers":    option.DOMAIN_NAME_SERVER_OPT,
+		"dhcp-server-identifier": option.SERVER_IDENTIFIER,
+	}
+	durationOptions := map[string]func(seconds int) option.DHCPOption{
+		"dhcp-lease-time":     newLeaseTimeOpt,
+		"dhcp-renewal-time":   option.NewT1Opt,
+		"dhcp-rebinding-time": option.NewT2Opt,
+	}
+
+	if optionType, ok := ipOptions[name]; ok {
+		ips, err := parseIPs(value)
+		if err != nil {
+			return err
+		}
+		ack.AddOption(option.NewIpListOpt(optionType, ips...))
+	} else if newOption, ok := durationOptions[name]; ok {
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		ack.AddOption(newOption(int(seconds)))
+	}
+
+	return nil
+}
+
+// parseDhclientTime parses either "<weekday> yyyy/mm/dd hh:mm:ss" in UTC or "epoch <seconds>". Zero time is returned for
+// "never", that dhclient writes for infinite lease.
+func parseDhclientTime(fields []string) (time.Time, error) {
+	if len(fields) == 1 && fields[0] == "never" {
+		return time.Time{}, nil
+	}
+
+	if len(fields) == 2 && fields[0] == "epoch" {
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0), nil
+	}
+
+	if len(fields) == 3 {
+		return time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized time: %v", strings.Join(fields, " "))
+}
+
+func parseNetworkdValue(ack *packet.DHCPPacket, key string, value string) error {
+	ipOptions := map[string]option.OptionType{
+		"NETMASK":        option.SUBNET_MASK,
+		"ROUTER":         option.ROUTER_OPT,
+		"DNS":            option.DOMAIN_NAME_SERVER_OPT,
+		"SERVER_ADDRESS": option.SERVER_IDENTIFIER,
+	}
+	durationOptions := map[string]func(seconds int) option.DHCPOption{
+		"LIFETIME": newLeaseTimeOpt,
+		"T1":       option.NewT1Opt,
+		"T2":       option.NewT2Opt,
+	}
+
+	if key == "ADDRESS" {
+		ips, err := parseIPs(value)
+		if err != nil {
+			return err
+		}
+		ack.Yiaddr = converter.IP2Array(ips[0])
+	} else if optionType, ok := ipOptions[key]; ok {
+		ips, err := parseIPs(strings.Join(strings.Fields(value), ","))
+		if err != nil {
+			return err
+		}
+		ack.AddOption(option.NewIpListOpt(optionType, ips...))
+	} else if newOption, ok := durationOptions[key]; ok {
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		ack.AddOption(newOption(int(seconds)))
+	}
+
+	return nil
+}
+
+// decodeRawAck decodes DHCP message as it is sent over the wire
+func decodeRawAck(data []byte) (packet.DHCPPacket, error) {
+	ack, err := packet.Decode(data, len(data))
+	if err != nil {
+		return packet.DHCPPacket{}, err
+	}
+
+	if ack.Yiaddr == [4]byte{} {
+		return packet.DHCPPacket{}, fmt.Errorf("DHCP message has no address")
+	}
+
+	return ack, nil
+}
+
+func leaseFromAck(ack packet.DHCPPacket, start time.Time) DHCPLease {
+	l := DHCPLease{
+		State:         INIT_REBOOT,
+		IpAddr:        net.IP(ack.Yiaddr[:]).To4(),
+		LeaseInitTime: start,
+		Offer:         ack,
+	}
+
+	if o := ack.GetOption(option.SERVER_IDENTIFIER); o != nil {
+		l.ServerIdentifier = o.GetDataAsIP4()
+	}
+
+	if o := ack.GetOption(option.SUBNET_MASK); o != nil {
+		l.SubnetMask = o.GetDataAsIpMask()
+	}
+
+	if o := ack.GetOption(option.DOMAIN_NAME_SERVER_OPT); o != nil {
+		if dns := option.DnsParser(*o); len(dns) > 0 {
+			l.Dns = dns[0]
+		}
+	}
+
+	if o := ack.GetOption(option.IP_ADDR_LEASE_TIME); o != nil {
+		l.LeaseDuration = o.GetDataAsSecDuration()
+	}
+
+	if o := ack.GetOption(option.RENEWAL_TIME_VALUE); o != nil {
+		l.T1 = o.GetDataAsSecDuration()
+	} else {
+		l.T1 = l.LeaseDuration / 2
+	}
+
+	if o := ack.GetOption(option.REBINDING_TIME_VALUE); o != nil {
+		l.T2 = o.GetDataAsSecDuration()
+	} else {
+		l.T2 = time.Duration(float64(l.LeaseDuration) * 0.875)
+	}
+
+	return l
+}
+
+func newImportedAck() packet.DHCPPacket {
+	ack := packet.DHCPPacket{Op: packet.REPLY}
+	ack.AddOption(option.NewMessageTypeOpt(option.DHCPACK))
+
+	return ack
+}
+
+func newLeaseTimeOpt(seconds int) option.DHCPOption {
+	return option.NewIpAddrLeaseTime(uint32(seconds))
+}
+
+func parseIPs(value string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, 2)
+	for _, s := range strings.Split(value, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s)).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("not an IPv4 address: %v", s)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+// stripComment removes '#' comment, that is not within quoted string
+func stripComment(line string) string {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '#' && !quoted:
+			return line[:i]
+		}
+	}
+
+	return line
+}
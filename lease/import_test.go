@@ -0,0 +1,83 @@
+package lease
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"math"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestImportDhclientLease(t *testing.T) {
+	l, err := ImportDhclientLease("testdata/dhclient.leases", "eth0")
+	assert.NoError(t, err)
+
+	// the last lease of the interface is the most recent one
+	assert.Equal(t, INIT_REBOOT, l.State)
+	assert.Equal(t, net.ParseIP("192.168.1.57").To4(), l.IpAddr)
+	assert.Equal(t, net.ParseIP("192.168.1.1").To4(), l.ServerIdentifier)
+	assert.Equal(t, net.ParseIP("192.168.1.1").To4(), l.Dns)
+	assert.Equal(t, net.IPv4Mask(255, 255, 255, 0), l.SubnetMask)
+	assert.Equal(t, 24*time.Hour, l.LeaseDuration)
+	assert.Equal(t, 12*time.Hour, l.T1)
+	assert.Equal(t, 21*time.Hour, l.T2)
+	assert.Equal(t, time.Date(2021, 8, 10, 22, 0, 0, 0, time.UTC), l.LeaseInitTime)
+	assert.Equal(t, []net.IP{net.ParseIP("192.168.1.1").To4(), net.ParseIP("8.8.8.8").To4()},
+		option.DnsParser(*l.Offer.GetOption(option.DOMAIN_NAME_SERVER_OPT)))
+	assert.Equal(t, net.ParseIP("192.168.1.1").To4(), l.Offer.GetOption(option.ROUTER_OPT).GetDataAsIP4())
+
+	l, err = ImportDhclientLease("testdata/dhclient.leases", "wlan0")
+	assert.NoError(t, err)
+	assert.Equal(t, net.ParseIP("10.0.0.23").To4(), l.IpAddr)
+	assert.Equal(t, time.Hour, l.T1)
+	assert.True(t, time.Unix(1628596800-7200, 0).Equal(l.LeaseInitTime))
+
+	// infinite lease
+	l, err = ImportDhclientLease("testdata/dhclient.leases", "usb0")
+	assert.NoError(t, err)
+	assert.Equal(t, net.ParseIP("172.16.0.9").To4(), l.IpAddr)
+	assert.Equal(t, time.Duration(math.MaxUint32)*time.Second, l.LeaseDuration)
+	assert.WithinDuration(t, time.Now(), l.LeaseInitTime, time.Minute)
+
+	_, err = ImportDhclientLease("testdata/dhclient.leases", "eth1")
+	assert.Error(t, err)
+}
+
+func TestImportDhcpcdLease(t *testing.T) {
+	l, err := ImportDhcpcdLease("testdata/dhcpcd-eth0.lease")
+	assert.NoError(t, err)
+
+	info, _ := os.Stat("testdata/dhcpcd-eth0.lease")
+	assert.Equal(t, INIT_REBOOT, l.State)
+	assert.Equal(t, net.ParseIP("192.168.1.57").To4(), l.IpAddr)
+	assert.Equal(t, net.ParseIP("192.168.1.1").To4(), l.ServerIdentifier)
+	assert.Equal(t, net.ParseIP("192.168.1.1").To4(), l.Dns)
+	assert.Equal(t, net.IPv4Mask(255, 255, 255, 0), l.SubnetMask)
+	assert.Equal(t, 24*time.Hour, l.LeaseDuration)
+	assert.Equal(t, 12*time.Hour, l.T1)
+	assert.Equal(t, 21*time.Hour, l.T2)
+	assert.Equal(t, info.ModTime(), l.LeaseInitTime)
+	assert.True(t, l.Offer.IsPacketOfType(option.DHCPACK))
+
+	_, err = ImportDhcpcdLease("testdata/dhclient.leases")
+	assert.Error(t, err)
+}
+
+func TestImportNetworkdLease(t *testing.T) {
+	l, err := ImportNetworkdLease("testdata/networkd-2.lease")
+	assert.NoError(t, err)
+
+	info, _ := os.Stat("testdata/networkd-2.lease")
+	assert.Equal(t, INIT_REBOOT, l.State)
+	assert.Equal(t, net.ParseIP("192.168.1.57").To4(), l.IpAddr)
+	assert.Equal(t, net.ParseIP("192.168.1.1").To4(), l.ServerIdentifier)
+	assert.Equal(t, net.ParseIP("192.168.1.1").To4(), l.Dns)
+	assert.Equal(t, net.IPv4Mask(255, 255, 255, 0), l.SubnetMask)
+	assert.Equal(t, 24*time.Hour, l.LeaseDuration)
+	assert.Equal(t, 12*time.Hour, l.T1)
+	assert.Equal(t, 21*time.Hour, l.T2)
+	assert.Equal(t, info.ModTime(), l.LeaseInitTime)
+	assert.Equal(t, 2, len(option.DnsParser(*l.Offer.GetOption(option.DOMAIN_NAME_SERVER_OPT))))
+}
@@ -1,14 +1,13 @@
 package lease
 
 import (
-	"encoding/hex"
 	"encoding/json"
-	"github.com/go-ini/ini"
 	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/util/clock"
 	netUtils "github.com/svishnyakoff/dhcpv4/util/net-utils"
+	"io/ioutil"
 	"log"
 	"net"
-	"os"
 	"sync"
 	"time"
 )
@@ -59,33 +58,22 @@ func NewDHCPLease() DHCPLease {
 	}
 }
 
+// LoadLease reads lease saved to the file returned by LegacyLeaseFile. New lease in INIT state is returned if the file
+// does not exist or cannot be parsed.
+//
+// Deprecated: leases are kept in LeaseStore, use LeaseStore.Load, or ImportLegacyLease to move lease saved to the file
+// into the store.
 func LoadLease() DHCPLease {
-	var leaseFile = os.Getenv("Lease.File")
-
-	if leaseFile == "" {
-		log.Println("File with lease dump is not provided. Use \"Lease." +
-			"File\" as a path to file with saved lease information")
-		// todo figure out where to store lease if file was not past in params
+	data, err := ioutil.ReadFile(LegacyLeaseFile())
+	if err != nil {
+		return NewDHCPLease()
 	}
 
-	cfg, err := ini.Load(leaseFile)
-
+	l, err := decodeIni(data)
 	if err != nil {
 		return NewDHCPLease()
 	}
 
-	l := DHCPLease{}
-
-	l.State = Parse(cfg.Section("").Key("state").In("INIT", StringCandidates()))
-	l.IpAddr = net.ParseIP(cfg.Section("").Key("ip").String()).To4()
-	l.Dns = net.ParseIP(cfg.Section("").Key("dns").String()).To4()
-	l.SubnetMask, _ = hex.DecodeString(cfg.Section("").Key("subnet.mask").String())
-	l.ServerIdentifier = net.ParseIP(cfg.Section("").Key("server.ip").String()).To4()
-	l.LeaseInitTime = cfg.Section("timers").Key("lease.start").MustTime()
-	l.LeaseDuration = cfg.Section("timers").Key("lease.duration").MustDuration()
-	l.T1 = cfg.Section("timers").Key("T1").MustDuration()
-	l.T2 = cfg.Section("timers").Key("T2").MustDuration()
-
 	return l
 }
 
@@ -107,29 +95,31 @@ func (l DHCPLease) GetLeaseExpirationMoment() time.Time {
 	return l.LeaseInitTime.Add(l.LeaseDuration)
 }
 
-func (l DHCPLease) IsRenewPeriodExpired() bool {
-	return time.Now().After(l.LeaseInitTime.Add(l.T2))
+func (l DHCPLease) IsRenewPeriodExpired(c clock.Clock) bool {
+	return c.Now().After(l.LeaseInitTime.Add(l.T2))
 }
 
-func (l DHCPLease) DurationUntilRenew() time.Duration {
+func (l DHCPLease) DurationUntilRenew(c clock.Clock) time.Duration {
 	t1Moment := l.LeaseInitTime.Add(l.T1)
+	now := c.Now()
 
-	if time.Now().Before(t1Moment) {
-		return t1Moment.Sub(time.Now())
+	if now.Before(t1Moment) {
+		return t1Moment.Sub(now)
 	}
 
 	return time.Second * 60
 }
 
-func (l DHCPLease) IsRebindPeriodExpired() bool {
-	return time.Now().After(l.LeaseInitTime.Add(l.LeaseDuration))
+func (l DHCPLease) IsRebindPeriodExpired(c clock.Clock) bool {
+	return c.Now().After(l.LeaseInitTime.Add(l.LeaseDuration))
 }
 
-func (l *DHCPLease) DurationUntilRebind() time.Duration {
+func (l *DHCPLease) DurationUntilRebind(c clock.Clock) time.Duration {
 	t2Moment := l.LeaseInitTime.Add(l.T2)
+	now := c.Now()
 
-	if time.Now().Before(t2Moment) {
-		return t2Moment.Sub(time.Now())
+	if now.Before(t2Moment) {
+		return t2Moment.Sub(now)
 	}
 
 	return time.Second * 60
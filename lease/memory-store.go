@@ -0,0 +1,53 @@
+package lease
+
+import "sync"
+
+type memoryLeaseStore struct {
+	lock   sync.Mutex
+	leases map[LeaseKey]DHCPLease
+}
+
+// NewMemoryLeaseStore creates store that keeps leases in memory only, so they do not survive process restart
+func NewMemoryLeaseStore() LeaseStore {
+	return &memoryLeaseStore{leases: make(map[LeaseKey]DHCPLease)}
+}
+
+func (s *memoryLeaseStore) Load(key LeaseKey) (DHCPLease, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l, ok := s.leases[key]
+	if !ok {
+		return DHCPLease{}, ErrLeaseNotFound
+	}
+
+	return l, nil
+}
+
+func (s *memoryLeaseStore) Save(key LeaseKey, l DHCPLease) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.leases[key] = l
+	return nil
+}
+
+func (s *memoryLeaseStore) Delete(key LeaseKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.leases, key)
+	return nil
+}
+
+func (s *memoryLeaseStore) List() ([]LeaseKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]LeaseKey, 0, len(s.leases))
+	for key := range s.leases {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
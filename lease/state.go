@@ -11,6 +11,9 @@ const (
 	BOUND
 	RENEWING
 	REBINDING
+	// INFORMED means host has statically configured address and obtained local configuration parameters with
+	// DHCPINFORM. There is no lease to maintain in this state.
+	INFORMED
 )
 
 func (state State) String() string {
@@ -33,5 +36,5 @@ func Parse(str string) State {
 
 func StringCandidates() []string {
 	return []string{"INIT_REBOOT", "REBOOTING", "INIT", "SELECTING", "REQUESTING", "BOUND", "RENEWING",
-		"REBINDING"}
+		"REBINDING", "INFORMED"}
 }
@@ -0,0 +1,97 @@
+package lease
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrLeaseNotFound is returned by LeaseStore.Load when store has no lease for the key
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// LeaseKey identifies lease among leases of several clients that share the same store. Besides the current lease,
+// client keeps the last lease of every network it was attached to, such leases have Network set, see NetworkKey.
+type LeaseKey struct {
+	InterfaceName    string
+	ClientIdentifier string
+	Network          string
+}
+
+func (k LeaseKey) String() string {
+	str := url.QueryEscape(k.InterfaceName) + "@" + url.QueryEscape(k.ClientIdentifier)
+	if k.Network != "" {
+		str += "@" + url.QueryEscape(k.Network)
+	}
+
+	return str
+}
+
+// ParseLeaseKey restores key from its string representation
+func ParseLeaseKey(str string) (LeaseKey, error) {
+	parts := strings.Split(str, "@")
+	if len(parts) != 2 && len(parts) != 3 {
+		return LeaseKey{}, fmt.Errorf("malformed lease key: %v", str)
+	}
+
+	for i, part := range parts {
+		unescaped, err := url.QueryUnescape(part)
+		if err != nil {
+			return LeaseKey{}, fmt.Errorf("malformed lease key %v: %v", str, err)
+		}
+		parts[i] = unescaped
+	}
+
+	key := LeaseKey{InterfaceName: parts[0], ClientIdentifier: parts[1]}
+	if len(parts) == 3 {
+		key.Network = parts[2]
+	}
+
+	return key, nil
+}
+
+// ForNetwork returns key of the lease cached for the network
+func (k LeaseKey) ForNetwork(network string) LeaseKey {
+	return LeaseKey{InterfaceName: k.InterfaceName, ClientIdentifier: k.ClientIdentifier, Network: network}
+}
+
+// NetworkKey identifies network by DHCP server and hardware address of the default gateway, that is unlikely to be
+// the same in two different networks even if they use the same private address range,
+// see https://datatracker.ietf.org/doc/html/rfc4436#section-2.1
+func NetworkKey(serverIdentifier net.IP, gatewayHardwareAddr net.HardwareAddr) string {
+	return serverIdentifier.String() + "-" + gatewayHardwareAddr.String()
+}
+
+// LeaseStore keeps leases between client restarts, so client can reuse them in INIT-REBOOT state
+type LeaseStore interface {
+	// Load returns lease saved under the key or ErrLeaseNotFound if there is no such lease
+	Load(key LeaseKey) (DHCPLease, error)
+	Save(key LeaseKey, l DHCPLease) error
+	// Delete removes lease saved under the key. Deleting missing lease is not an error.
+	Delete(key LeaseKey) error
+	List() ([]LeaseKey, error)
+}
+
+// DefaultLeaseDir returns directory within user cache directory, or within temporary directory if cache directory
+// is not known, where leases are saved by default
+func DefaultLeaseDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "dhcpv4")
+}
+
+// LegacyLeaseFile returns path of the file single lease was saved to before leases were kept in LeaseStore. The path
+// is taken from "Lease.File" env variable and falls back to lease.ini within DefaultLeaseDir.
+func LegacyLeaseFile() string {
+	if leaseFile := os.Getenv("Lease.File"); leaseFile != "" {
+		return leaseFile
+	}
+
+	return filepath.Join(DefaultLeaseDir(), "lease.ini")
+}
@@ -0,0 +1,129 @@
+package lease
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/packet/option"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLeaseStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcpv4-lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stores := map[string]LeaseStore{
+		"ini":    NewIniLeaseStore(dir),
+		"json":   NewJsonLeaseStore(dir),
+		"memory": NewMemoryLeaseStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			eth0 := LeaseKey{InterfaceName: "eth0", ClientIdentifier: "0a1b2c3d4e5f"}
+			eth1 := LeaseKey{InterfaceName: "eth1", ClientIdentifier: "client@host", Network: "192.168.0.1-52:54:00:00:00:01"}
+			l := createLease()
+
+			_, err := store.Load(eth0)
+			assert.Equal(t, ErrLeaseNotFound, err)
+
+			assert.NoError(t, store.Save(eth0, l))
+			assert.NoError(t, store.Save(eth1, NewDHCPLease()))
+
+			loaded, err := store.Load(eth0)
+			assert.NoError(t, err)
+			assert.Equal(t, l.State, loaded.State)
+			assert.Equal(t, l.IpAddr, loaded.IpAddr)
+			assert.Equal(t, l.Dns, loaded.Dns)
+			assert.Equal(t, l.SubnetMask, loaded.SubnetMask)
+			assert.Equal(t, l.ServerIdentifier, loaded.ServerIdentifier)
+			assert.True(t, l.LeaseInitTime.Equal(loaded.LeaseInitTime))
+			assert.Equal(t, l.LeaseDuration, loaded.LeaseDuration)
+			assert.Equal(t, l.T1, loaded.T1)
+			assert.Equal(t, l.T2, loaded.T2)
+			assert.Equal(t, l.Offer.Encode(), loaded.Offer.Encode())
+
+			keys, err := store.List()
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []LeaseKey{eth0, eth1}, keys)
+
+			assert.NoError(t, store.Delete(eth0))
+			assert.NoError(t, store.Delete(eth0))
+
+			keys, err = store.List()
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []LeaseKey{eth1}, keys)
+			assert.NoError(t, store.Delete(eth1))
+		})
+	}
+}
+
+func TestImportLegacyLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcpv4-lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	leaseFile := filepath.Join(dir, "lease.ini")
+	l := createLease()
+	data, _ := encodeIni(l)
+	assert.NoError(t, ioutil.WriteFile(leaseFile, data, 0644))
+	store := NewIniLeaseStore(dir)
+	key := LeaseKey{InterfaceName: "eth0", ClientIdentifier: "0a1b2c3d4e5f"}
+
+	imported, err := ImportLegacyLease(leaseFile, store, key)
+
+	assert.NoError(t, err)
+	assert.Equal(t, l.IpAddr, imported.IpAddr)
+	loaded, err := store.Load(key)
+	assert.NoError(t, err)
+	assert.Equal(t, l.IpAddr, loaded.IpAddr)
+	assert.Equal(t, l.Offer.Encode(), loaded.Offer.Encode())
+	assert.FileExists(t, leaseFile)
+
+	_, err = ImportLegacyLease(filepath.Join(dir, "missing.ini"), store, key)
+	assert.Equal(t, ErrLeaseNotFound, err)
+}
+
+func TestLoadLeaseFromLegacyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcpv4-lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	leaseFile := filepath.Join(dir, "lease.ini")
+	l := createLease()
+	data, _ := encodeIni(l)
+	assert.NoError(t, ioutil.WriteFile(leaseFile, data, 0644))
+	os.Setenv("Lease.File", leaseFile)
+	defer os.Unsetenv("Lease.File")
+
+	loaded := LoadLease()
+
+	assert.Equal(t, l.IpAddr, loaded.IpAddr)
+	assert.Equal(t, l.State, loaded.State)
+	assert.Equal(t, l.Offer.Encode(), loaded.Offer.Encode())
+}
+
+func createLease() DHCPLease {
+	ack := packet.DHCPPacket{Op: packet.REPLY, Xid: 10}
+	ack.AddOption(option.NewMessageTypeOpt(option.DHCPACK))
+	ack.AddOption(option.NewIpAddrLeaseTime(200))
+	ack.AddOption(option.NewServerIdentifierOpt(net.ParseIP("192.168.0.1").To4()))
+
+	return DHCPLease{
+		State:            BOUND,
+		IpAddr:           net.ParseIP("192.168.0.10").To4(),
+		Dns:              net.ParseIP("192.168.0.1").To4(),
+		SubnetMask:       net.IPv4Mask(255, 255, 255, 0),
+		ServerIdentifier: net.ParseIP("192.168.0.1").To4(),
+		LeaseInitTime:    time.Now(),
+		LeaseDuration:    200 * time.Second,
+		T1:               100 * time.Second,
+		T2:               175 * time.Second,
+		Offer:            ack,
+	}
+}
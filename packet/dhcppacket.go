@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	. "github.com/svishnyakoff/dhcpv4/packet/option"
+	"io"
 	"log"
 	"net"
 	"strconv"
@@ -40,134 +40,146 @@ type DHCPPacket struct {
 	File  [128]byte
 
 	// DHCP message type option is required
-	options []byte // first 4 octets must be 99, 130, 83, and 99
+	options []DHCPOption
+	index   [256]uint16 // position of option plus one by option code, 0 if packet does not have the option
 }
 
+// Encode encodes the packet the way it is sent over the wire. Options that would make the message longer than
+// MinMessageSize are moved to 'file' and 'sname' fields, see EncodeWithMaxSize.
 func (packet DHCPPacket) Encode() []byte {
-	buf := new(bytes.Buffer)
-
-	binary.Write(buf, binary.BigEndian, packet.Op)
-	binary.Write(buf, binary.BigEndian, packet.Htype)
-	binary.Write(buf, binary.BigEndian, packet.Hlen)
-	binary.Write(buf, binary.BigEndian, packet.Hops)
-
-	binary.Write(buf, binary.BigEndian, packet.Xid)
-
-	binary.Write(buf, binary.BigEndian, packet.Secs)
-	binary.Write(buf, binary.BigEndian, packet.Flags)
-
-	binary.Write(buf, binary.BigEndian, packet.Ciaddr)
-	binary.Write(buf, binary.BigEndian, packet.Yiaddr)
-	binary.Write(buf, binary.BigEndian, packet.Siaddr)
-	binary.Write(buf, binary.BigEndian, packet.Giaddr)
-	binary.Write(buf, binary.BigEndian, packet.Chaddr)
-
-	binary.Write(buf, binary.BigEndian, packet.Sname)
-	binary.Write(buf, binary.BigEndian, packet.File)
+	return packet.EncodeWithMaxSize(MinMessageSize)
+}
 
-	options := make([]byte, 0, len(packet.options)+7)
-	options = append(options, 99, 130, 83, 99) // magic cookie
-	options = append(options, packet.options[:]...)
-	options = append(options, 255, 0, 0) // "end" tag
+// EncodeWithMaxSize encodes the packet so it fits into maxSize bytes, such as the size receiver announced with
+// MAX_DHCP_MESSAGE_SIZE option. Options that do not fit into options field are moved to 'file' and 'sname' fields
+// unless those hold boot file name and server name.
+func (packet DHCPPacket) EncodeWithMaxSize(maxSize int) []byte {
+	// moving options to 'file' and 'sname' fields only makes options field shorter
+	buf := make([]byte, fixedPacketSize+3+packet.encodedOptionsLen())
+	n, _ := packet.marshalTo(buf, maxSize)
 
-	binary.Write(buf, binary.BigEndian, options)
+	return buf[:n]
+}
 
-	return buf.Bytes()
+// MarshalBinary encodes the packet the way Encode does, it implements encoding.BinaryMarshaler
+func (packet DHCPPacket) MarshalBinary() ([]byte, error) {
+	return packet.Encode(), nil
 }
 
-func Decode(buf []byte, bytesRead int) (packet DHCPPacket, e error) {
+// MarshalTo encodes the packet the way Encode does into buf, and returns the number of bytes written. Returned error
+// wraps io.ErrShortBuffer if buf is too short. Unlike Encode, it does not allocate memory, unless options do not fit
+// into MinMessageSize and are moved to 'file' and 'sname' fields.
+func (packet DHCPPacket) MarshalTo(buf []byte) (int, error) {
+	return packet.marshalTo(buf, MinMessageSize)
+}
 
-	defer func() {
-		if r := recover(); r != nil {
-			packet = DHCPPacket{}
-			if er, ok := r.(error); ok {
-				e = errors.New("DHCP command parse error: " + er.Error())
-			} else {
-				e = fmt.Errorf("DHCP command parse error: %v", r)
-			}
-		}
-	}()
+func (packet DHCPPacket) marshalTo(buf []byte, maxSize int) (int, error) {
+	var options []byte // options field moved to 'file' and 'sname' fields by overload
+	optionsLen := packet.encodedOptionsLen()
 
-	handleReadError := func(err error) {
-		if err != nil {
-			panic(err)
-		}
+	if fixedPacketSize+optionsLen > maxSize && !packet.HasOption(OPT_OVERLOAD) {
+		packet, options = packet.overload(maxSize)
+		optionsLen = len(options)
 	}
 
-	b := bytes.NewReader(buf[:bytesRead])
-	packet = DHCPPacket{}
-
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Op))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Htype))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Hlen))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Hops))
+	size := fixedPacketSize + optionsLen
+	if len(buf) < size {
+		return 0, fmt.Errorf("%w: packet is %d bytes long, but buffer is %d bytes long", io.ErrShortBuffer, size,
+			len(buf))
+	}
 
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Xid))
+	buf[0], buf[1], buf[2], buf[3] = packet.Op, packet.Htype, packet.Hlen, packet.Hops
+	binary.BigEndian.PutUint32(buf[4:8], packet.Xid)
+	binary.BigEndian.PutUint16(buf[8:10], packet.Secs)
+	binary.BigEndian.PutUint16(buf[10:12], packet.Flags)
+	copy(buf[12:16], packet.Ciaddr[:])
+	copy(buf[16:20], packet.Yiaddr[:])
+	copy(buf[20:24], packet.Siaddr[:])
+	copy(buf[24:28], packet.Giaddr[:])
+	copy(buf[28:44], packet.Chaddr[:])
+	copy(buf[44:108], packet.Sname[:])
+	copy(buf[108:headerSize], packet.File[:])
+
+	n := headerSize + copy(buf[headerSize:], magicCookie)
+	if options != nil {
+		n += copy(buf[n:], options)
+	} else {
+		n += len(packet.appendOptions(buf[n:n]))
+	}
 
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Secs))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Flags))
+	// "end" tag
+	buf[n], buf[n+1], buf[n+2] = byte(END), 0, 0
 
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Ciaddr))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Yiaddr))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Siaddr))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Giaddr))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Chaddr))
+	return n + 3, nil
+}
 
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.Sname))
-	handleReadError(binary.Read(b, binary.BigEndian, &packet.File))
+// Decode decodes packet received over the wire. Options carried by 'file' and 'sname' fields are merged into options
+// of the packet. Returned error wraps one of ErrTruncated, ErrBadCookie or ErrBadOptionLength. Decoded packet does not
+// reference buf, so buf may be reused for the next packet.
+func Decode(buf []byte, bytesRead int) (DHCPPacket, error) {
+	if bytesRead > len(buf) {
+		bytesRead = len(buf)
+	}
 
-	packet.options = make([]byte, b.Len())
-	handleReadError(binary.Read(b, binary.BigEndian, packet.options))
+	data := make([]byte, bytesRead)
+	copy(data, buf)
 
-	// ignore magic cookie and end tag
-	packet.options = packet.options[4 : len(packet.options)-3]
+	packet := DHCPPacket{}
+	if err := packet.UnmarshalBinary(data); err != nil {
+		return DHCPPacket{}, err
+	}
 
 	return packet, nil
 }
 
-func (packet *DHCPPacket) AddOption(option DHCPOption) {
-	if packet.options == nil {
-		packet.options = make([]byte, 0)
+// UnmarshalBinary decodes packet received over the wire into the packet the way Decode does, it implements
+// encoding.BinaryUnmarshaler. Unlike Decode, it does not allocate memory, unless options are split or carried by
+// 'file' and 'sname' fields, so it suits decoding many packets into the same DHCPPacket. To avoid copying, options
+// keep referencing data and reuse memory of options the packet held before, so data is not to be modified while the
+// packet is in use, and copies of the packet made before the call are not to be used after it. Once error is
+// returned, the packet is left partly overwritten and is not to be used until next successful call.
+func (packet *DHCPPacket) UnmarshalBinary(data []byte) error {
+	optionsStart := headerSize + len(magicCookie)
+	if len(data) < optionsStart {
+		return fmt.Errorf("%w: packet is %d bytes long, at least %d bytes expected", ErrTruncated, len(data),
+			optionsStart)
 	}
 
-	packet.options = append(packet.options, option.Data...)
-}
+	if !bytes.Equal(data[headerSize:optionsStart], magicCookie) {
+		return fmt.Errorf("%w: %v", ErrBadCookie, data[headerSize:optionsStart])
+	}
 
-func (packet DHCPPacket) GetOptions() []DHCPOption {
-	options := packet.options
-
-	decodedOptions := make(map[OptionType]DHCPOption)
-	for i := 0; i < len(options); {
-		optionId := OptionType(options[i])
-		optionLen := int(options[i+1])
-		option := DHCPOption{}
-
-		nextOptionIndex := i + 2 + optionLen
-		option.ID = TypeToString(optionId)
-		//extract option subarray one by one and shift indexes to point next option
-		option.Data = options[i:nextOptionIndex]
-		i = nextOptionIndex
-
-		existingOption, ok := decodedOptions[optionId]
-
-		if !ok {
-			decodedOptions[optionId] = option
-		} else {
-			// https://datatracker.ietf.org/doc/html/rfc3396
-			// In the case that a decoding agent finds a split option, it MUST treat
-			// the contents of that option as a single option
-			existingOption.Data = append(existingOption.Data, option.Data[2:]...)
-			existingOption.Data[1] += option.Data[1]
-		}
+	packet.Op, packet.Htype, packet.Hlen, packet.Hops = data[0], data[1], data[2], data[3]
+	packet.Xid = binary.BigEndian.Uint32(data[4:8])
+	packet.Secs = binary.BigEndian.Uint16(data[8:10])
+	packet.Flags = binary.BigEndian.Uint16(data[10:12])
+	copy(packet.Ciaddr[:], data[12:16])
+	copy(packet.Yiaddr[:], data[16:20])
+	copy(packet.Siaddr[:], data[20:24])
+	copy(packet.Giaddr[:], data[24:28])
+	copy(packet.Chaddr[:], data[28:44])
+	copy(packet.Sname[:], data[44:108])
+	copy(packet.File[:], data[108:headerSize])
+
+	packet.options = packet.options[:0]
+	packet.index = [256]uint16{}
+
+	if err := packet.addEncodedOptions(data[optionsStart:]); err != nil {
+		return err
 	}
 
-	result := make([]DHCPOption, 0, 10)
+	if err := packet.mergeOverloadedOptions(); err != nil {
+		return err
+	}
 
-	for _, value := range decodedOptions {
-		result = append(result, value)
+	// split options are validated once they are concatenated, as they may be split at any byte
+	for _, o := range packet.options {
+		if err := validateOptionLength(OptionType(o.Data[0]), len(o.GetRawOptionValue())); err != nil {
+			return err
+		}
 	}
 
-	return result
+	return nil
 }
 
 func (packet DHCPPacket) GetMessageType() MessageType {
@@ -186,16 +198,6 @@ func (packet DHCPPacket) IsPacketOfType(messageType MessageType) bool {
 	return t == messageType
 }
 
-func (packet DHCPPacket) GetOption(optionType OptionType) *DHCPOption {
-	for _, opt := range packet.GetOptions() {
-		if opt.ID == optionType.String() {
-			return &opt
-		}
-	}
-
-	return nil
-}
-
 func (packet DHCPPacket) BroadcastFlag() bool {
 	return (1 << 15 & packet.Flags) != 0
 }
@@ -213,6 +215,12 @@ func (packet DHCPPacket) String() string {
 		})
 	}
 
+	// received packet may claim hardware address longer than 'chaddr' field
+	hlen := int(packet.Hlen)
+	if hlen > len(packet.Chaddr) {
+		hlen = len(packet.Chaddr)
+	}
+
 	k := toStringStruct{
 		Htype:   packet.Htype,
 		Hlen:    packet.Hlen,
@@ -224,7 +232,7 @@ func (packet DHCPPacket) String() string {
 		Yiaddr:  fmt.Sprintf("%v", net.IP(packet.Yiaddr[:])),
 		Siaddr:  fmt.Sprintf("%v", net.IP(packet.Siaddr[:])),
 		Giaddr:  fmt.Sprintf("%v", net.IP(packet.Giaddr[:])),
-		Chaddr:  fmt.Sprintf("%v", net.HardwareAddr(packet.Chaddr[:packet.Hlen])),
+		Chaddr:  fmt.Sprintf("%v", net.HardwareAddr(packet.Chaddr[:hlen])),
 		Sname:   asShortString(packet.Sname[:]),
 		File:    asShortString(packet.File[:]),
 		Options: packet.GetOptions(),
@@ -1,9 +1,13 @@
 package packet
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/svishnyakoff/dhcpv4/packet/option"
 	"github.com/svishnyakoff/dhcpv4/util/converter"
+	"io"
 	"net"
 	"testing"
 )
@@ -55,3 +59,423 @@ func TestDHCPPacket_GetOptions(t *testing.T) {
 	options := packet.GetOptions()
 	assert.Contains(t, options, option.NewMessageTypeOpt(option.DHCPDISCOVER))
 }
+
+func TestDecodeOptionsFromFileAndSname(t *testing.T) {
+	packet := createPacket()
+	packet.AddOption(option.NewMessageTypeOpt(option.DHCPACK))
+	packet.AddOption(option.NewOverloadOpt(option.OVERLOAD_BOTH))
+	copy(packet.File[:], append(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.1")).Data, 0, 0, 255))
+	copy(packet.Sname[:], append(option.NewMessageOpt("hello").Data, 255))
+
+	encoded := packet.Encode()
+	decoded, err := Decode(encoded, len(encoded))
+
+	assert.NoError(t, err)
+	assert.Equal(t, option.DHCPACK, decoded.GetMessageType())
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), decoded.GetOption(option.ROUTER_OPT).GetDataAsIP4())
+	assert.Equal(t, "hello", string(decoded.GetOption(option.MESSAGE).GetRawOptionValue()))
+	assert.Nil(t, decoded.GetOption(option.OPT_OVERLOAD))
+	assert.Equal(t, [128]byte{}, decoded.File)
+	assert.Equal(t, [64]byte{}, decoded.Sname)
+}
+
+func TestEncodeSpillsOptionsIntoFileAndSname(t *testing.T) {
+	ips := func(n int) []net.IP {
+		res := make([]net.IP, n)
+		for i := range res {
+			res[i] = net.IPv4(10, 0, 0, byte(i))
+		}
+		return res
+	}
+
+	packet := createPacket()
+	options := []option.DHCPOption{
+		option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewIpListOpt(option.ROUTER_OPT, ips(60)...),
+		option.NewIpListOpt(option.DOMAIN_NAME_SERVER_OPT, ips(30)...),
+		option.NewIpListOpt(option.NET_TIME_PROTOCOL_SERVERS_OPT, ips(10)...),
+		option.NewIpListOpt(option.TIME_SERVER_OPT, ips(14)...),
+	}
+	for _, o := range options {
+		packet.AddOption(o)
+	}
+
+	encoded := packet.EncodeWithMaxSize(MinMessageSize)
+	decoded, err := Decode(encoded, len(encoded))
+
+	assert.True(t, len(encoded) <= MinMessageSize, "encoded packet has %d bytes", len(encoded))
+	assert.Equal(t, byte(option.DOMAIN_NAME_SERVER_OPT), encoded[108], "file field starts with options")
+	assert.Equal(t, byte(option.TIME_SERVER_OPT), encoded[44], "sname field starts with options")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, options, decoded.GetOptions())
+	assert.Equal(t, len(encoded), len(packet.EncodeWithMaxSize(MinMessageSize)))
+	assert.True(t, len(packet.EncodeWithMaxSize(1500)) > MinMessageSize)
+}
+
+// rawPacket builds packet as it is received over the wire, with given bytes following magic cookie
+func rawPacket(options ...byte) []byte {
+	buf := make([]byte, 236, 300)
+	buf[0] = REPLY
+	buf = append(buf, 99, 130, 83, 99)
+
+	return append(buf, options...)
+}
+
+func TestDecodePaddedPacket(t *testing.T) {
+	buf := rawPacket(0, 0, 53, 1, 5, 0, 54, 4, 10, 0, 0, 1, 255, 0, 0, 0, 0, 0, 12, 200, 0)
+
+	decoded, err := Decode(buf, len(buf))
+
+	assert.NoError(t, err)
+	assert.Equal(t, option.DHCPACK, decoded.GetMessageType())
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), decoded.GetOption(option.SERVER_IDENTIFIER).GetDataAsIP4())
+	assert.Len(t, decoded.GetOptions(), 2)
+}
+
+func TestDecodeMalformedPacket(t *testing.T) {
+	badCookie := rawPacket(53, 1, 5, 255)
+	badCookie[236] = 98
+
+	tests := map[string]struct {
+		buf      []byte
+		expected error
+	}{
+		"truncated header":               {rawPacket()[:100], ErrTruncated},
+		"missing cookie":                 {rawPacket()[:238], ErrTruncated},
+		"bad cookie":                     {badCookie, ErrBadCookie},
+		"missing option length":          {rawPacket(53, 1, 5, 54), ErrTruncated},
+		"option longer than packet":      {rawPacket(53, 1, 5, 54, 4, 10, 0), ErrTruncated},
+		"message type of 2 bytes":        {rawPacket(53, 2, 5, 0, 255), ErrBadOptionLength},
+		"router list of 5 bytes":         {rawPacket(53, 1, 5, 3, 5, 10, 0, 0, 1, 1, 255), ErrBadOptionLength},
+		"truncated option in file field": {overloadedFile(54, 4, 10), ErrTruncated},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Decode(test.buf, len(test.buf))
+
+			assert.True(t, errors.Is(err, test.expected), "unexpected error: %v", err)
+		})
+	}
+}
+
+// overloadedFile builds packet whose 'file' field ends with given options, that follow padding
+func overloadedFile(fileOptions ...byte) []byte {
+	buf := rawPacket(53, 1, 5, 52, 1, 1, 255)
+	copy(buf[236-len(fileOptions):236], fileOptions)
+
+	return buf
+}
+
+func TestLongOptionIsSplitAndConcatenated(t *testing.T) {
+	routes := make([]byte, 600)
+	for i := range routes {
+		routes[i] = byte(i)
+	}
+
+	packet := createPacket()
+	packet.AddOption(option.NewMessageTypeOpt(option.DHCPACK))
+	packet.AddOption(option.NewOption(option.CLASSLESS_STATIC_ROUTE, routes))
+	packet.AddOption(option.NewMessageOpt("hello"))
+
+	encoded := packet.EncodeWithMaxSize(1500)
+	decoded, err := Decode(encoded, len(encoded))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{121, 255}, encoded[243:245], "first part of option holds 255 bytes")
+	assert.Equal(t, []byte{121, 255}, encoded[500:502], "second part of option holds 255 bytes")
+	assert.Equal(t, []byte{121, 90}, encoded[757:759], "last part of option holds the rest")
+	assert.Equal(t, []option.DHCPOption{
+		option.NewMessageTypeOpt(option.DHCPACK),
+		option.NewOption(option.CLASSLESS_STATIC_ROUTE, routes),
+		option.NewMessageOpt("hello"),
+	}, decoded.GetOptions())
+}
+
+func TestSplitOptionKeepsOrderInFileField(t *testing.T) {
+	routes := make([]byte, 300)
+	for i := range routes {
+		routes[i] = byte(i)
+	}
+
+	packet := createPacket()
+	packet.AddOption(option.NewMessageTypeOpt(option.DHCPACK))
+	packet.AddOption(option.NewOption(option.DOMAIN_NAME, make([]byte, 60)))
+	packet.AddOption(option.NewOption(option.CLASSLESS_STATIC_ROUTE, routes))
+
+	encoded := packet.Encode()
+	decoded, err := Decode(encoded, len(encoded))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{121, 45}, encoded[108:110], "file field holds last part of option")
+	assert.Equal(t, routes, decoded.GetOption(option.CLASSLESS_STATIC_ROUTE).GetRawOptionValue())
+}
+
+func TestDecodeSplitOption(t *testing.T) {
+	buf := rawPacket(53, 1, 5, 3, 3, 10, 0, 0, 54, 4, 10, 0, 0, 1, 3, 5, 1, 10, 0, 0, 2, 255)
+
+	decoded, err := Decode(buf, len(buf))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}},
+		decoded.GetOption(option.ROUTER_OPT).GetDataAsIP4Slice())
+	assert.Equal(t, option.ROUTER_OPT.String(), decoded.GetOptions()[1].ID, "option keeps its first position")
+}
+
+func TestSetAndDeleteOptions(t *testing.T) {
+	packet := createPacket()
+	packet.AddOption(option.NewMessageTypeOpt(option.DHCPDISCOVER))
+	packet.AddOption(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.1")))
+	packet.AddOption(option.NewMessageOpt("hello"))
+
+	packet.SetOption(option.NewMessageTypeOpt(option.DHCPREQUEST))
+	packet.SetOption(option.NewServerIdentifierOpt(net.ParseIP("10.0.0.2")))
+	assert.True(t, packet.DeleteOption(option.ROUTER_OPT))
+	assert.False(t, packet.DeleteOption(option.ROUTER_OPT))
+
+	expected := []option.DHCPOption{
+		option.NewMessageTypeOpt(option.DHCPREQUEST),
+		option.NewMessageOpt("hello"),
+		option.NewServerIdentifierOpt(net.ParseIP("10.0.0.2")),
+	}
+	assert.Equal(t, expected, packet.GetOptions())
+	assert.False(t, packet.HasOption(option.ROUTER_OPT))
+	assert.True(t, packet.HasOption(option.SERVER_IDENTIFIER))
+	assert.Equal(t, "hello", string(packet.GetOption(option.MESSAGE).GetRawOptionValue()))
+
+	encoded := packet.Encode()
+	decoded, err := Decode(encoded, len(encoded))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, decoded.GetOptions())
+	assert.Equal(t, []byte{53, 1, 3, 56, 5}, encoded[240:245], "options are encoded in order they were added")
+}
+
+func TestPacketCopiesDoNotShareOptions(t *testing.T) {
+	original := createPacket()
+	original.AddOption(option.NewMessageTypeOpt(option.DHCPDISCOVER))
+	original.AddOption(option.NewMessageOpt("hello"))
+
+	modified := original
+	modified.SetOption(option.NewMessageTypeOpt(option.DHCPREQUEST))
+	modified.DeleteOption(option.MESSAGE)
+	modified.AddOption(option.NewServerIdentifierOpt(net.ParseIP("10.0.0.2")))
+
+	assert.Equal(t, []option.DHCPOption{
+		option.NewMessageTypeOpt(option.DHCPDISCOVER),
+		option.NewMessageOpt("hello"),
+	}, original.GetOptions())
+	assert.Equal(t, option.DHCPREQUEST, modified.GetMessageType())
+	assert.False(t, original.HasOption(option.SERVER_IDENTIFIER))
+}
+
+func TestAddOptionKeepsOptionsOfSameCodeApart(t *testing.T) {
+	packet := createPacket()
+	packet.AddOption(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.1")))
+	packet.AddOption(option.NewMessageOpt("hello"))
+	packet.AddOption(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.2")))
+
+	assert.Len(t, packet.GetOptions(), 3)
+	assert.Equal(t, option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.1")), *packet.GetOption(option.ROUTER_OPT))
+
+	// receiver treats options of the same code as a single split option
+	encoded := packet.Encode()
+	decoded, err := Decode(encoded, len(encoded))
+	assert.NoError(t, err)
+	assert.Equal(t, option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")),
+		*decoded.GetOption(option.ROUTER_OPT))
+
+	replaced := packet
+	replaced.SetOption(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.3")))
+	assert.Equal(t, []option.DHCPOption{
+		option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.3")),
+		option.NewMessageOpt("hello"),
+	}, replaced.GetOptions())
+
+	assert.True(t, packet.DeleteOption(option.ROUTER_OPT))
+	assert.False(t, packet.HasOption(option.ROUTER_OPT))
+	assert.Equal(t, []option.DHCPOption{option.NewMessageOpt("hello")}, packet.GetOptions())
+	assert.Equal(t, "hello", string(packet.GetOption(option.MESSAGE).GetRawOptionValue()))
+}
+
+// ackPacket builds DHCPACK packet as DHCP server sends it
+func ackPacket() DHCPPacket {
+	packet := createPacket()
+	packet.Op = REPLY
+	packet.Yiaddr = converter.IP2Array(net.ParseIP("10.0.0.5").To4())
+	packet.AddOption(option.NewMessageTypeOpt(option.DHCPACK))
+	packet.AddOption(option.NewServerIdentifierOpt(net.ParseIP("10.0.0.1")))
+	packet.AddOption(option.NewIpAddrLeaseTime(3600))
+	packet.AddOption(option.NewT1Opt(1800))
+	packet.AddOption(option.NewT2Opt(3150))
+	packet.AddOption(option.NewIpListOpt(option.SUBNET_MASK, net.ParseIP("255.255.255.0")))
+	packet.AddOption(option.NewIpListOpt(option.ROUTER_OPT, net.ParseIP("10.0.0.1")))
+	packet.AddOption(option.NewIpListOpt(option.DOMAIN_NAME_SERVER_OPT, net.ParseIP("8.8.8.8"), net.ParseIP("8.8.4.4")))
+
+	return packet
+}
+
+func TestMarshalToMatchesEncode(t *testing.T) {
+	packet := ackPacket()
+	buf := make([]byte, 1500)
+
+	n, err := packet.MarshalTo(buf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, packet.Encode(), buf[:n])
+
+	_, err = packet.MarshalTo(buf[:n-1])
+	assert.True(t, errors.Is(err, io.ErrShortBuffer), "unexpected error: %v", err)
+}
+
+func TestUnmarshalBinaryReusesPacket(t *testing.T) {
+	first, second := ackPacket(), createPacket()
+	second.AddOption(option.NewMessageTypeOpt(option.DHCPNAK))
+	second.AddOption(option.NewMessageOpt("address is taken"))
+
+	decoded := DHCPPacket{}
+	assert.NoError(t, decoded.UnmarshalBinary(first.Encode()))
+	assert.Equal(t, first.GetOptions(), decoded.GetOptions())
+
+	assert.NoError(t, decoded.UnmarshalBinary(second.Encode()))
+	assert.Equal(t, second.Xid, decoded.Xid)
+	assert.Equal(t, second.GetOptions(), decoded.GetOptions())
+	assert.False(t, decoded.HasOption(option.SERVER_IDENTIFIER))
+}
+
+func TestMarshalToAndUnmarshalBinaryDoNotAllocate(t *testing.T) {
+	packet := ackPacket()
+	buf := make([]byte, 1500)
+	encoded := packet.Encode()
+	decoded := DHCPPacket{}
+	decoded.UnmarshalBinary(encoded)
+
+	assert.Zero(t, testing.AllocsPerRun(100, func() { packet.MarshalTo(buf) }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { decoded.UnmarshalBinary(encoded) }))
+}
+
+// legacyEncode encodes the packet with reflection based binary.Write, the way Encode did before MarshalTo was added.
+// It is kept to compare MarshalTo against it.
+func legacyEncode(packet DHCPPacket) []byte {
+	buf := new(bytes.Buffer)
+
+	for _, field := range []interface{}{packet.Op, packet.Htype, packet.Hlen, packet.Hops, packet.Xid, packet.Secs,
+		packet.Flags, packet.Ciaddr, packet.Yiaddr, packet.Siaddr, packet.Giaddr, packet.Chaddr, packet.Sname,
+		packet.File} {
+		binary.Write(buf, binary.BigEndian, field)
+	}
+
+	options := make([]byte, 0, packet.encodedOptionsLen()+7)
+	options = append(options, magicCookie...)
+	options = packet.appendOptions(options)
+	options = append(options, 255, 0, 0) // "end" tag
+	binary.Write(buf, binary.BigEndian, options)
+
+	return buf.Bytes()
+}
+
+// legacyDecode decodes the packet with reflection based binary.Read, the way Decode did before UnmarshalBinary was
+// added. It is kept to compare UnmarshalBinary against it.
+func legacyDecode(buf []byte) (DHCPPacket, error) {
+	b := bytes.NewReader(buf)
+	packet := DHCPPacket{}
+
+	for _, field := range []interface{}{&packet.Op, &packet.Htype, &packet.Hlen, &packet.Hops, &packet.Xid,
+		&packet.Secs, &packet.Flags, &packet.Ciaddr, &packet.Yiaddr, &packet.Siaddr, &packet.Giaddr, &packet.Chaddr,
+		&packet.Sname, &packet.File} {
+		if err := binary.Read(b, binary.BigEndian, field); err != nil {
+			return DHCPPacket{}, err
+		}
+	}
+
+	options := make([]byte, b.Len())
+	if err := binary.Read(b, binary.BigEndian, options); err != nil {
+		return DHCPPacket{}, err
+	}
+
+	err := packet.addEncodedOptions(options[len(magicCookie):])
+	return packet, err
+}
+
+func TestLegacyCodecMatchesCurrentOne(t *testing.T) {
+	packet := ackPacket()
+	encoded := packet.Encode()
+	decoded, err := legacyDecode(encoded)
+
+	assert.Equal(t, encoded, legacyEncode(packet))
+	assert.NoError(t, err)
+	assert.Equal(t, packet.GetOptions(), decoded.GetOptions())
+}
+
+func BenchmarkLegacyEncode(b *testing.B) {
+	packet := ackPacket()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		legacyEncode(packet)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	packet := ackPacket()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		packet.Encode()
+	}
+}
+
+func BenchmarkMarshalTo(b *testing.B) {
+	packet := ackPacket()
+	buf := make([]byte, 1500)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		packet.MarshalTo(buf)
+	}
+}
+
+func BenchmarkLegacyDecode(b *testing.B) {
+	encoded := ackPacket().Encode()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		legacyDecode(encoded)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	encoded := ackPacket().Encode()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		Decode(encoded, len(encoded))
+	}
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	encoded := ackPacket().Encode()
+	packet := DHCPPacket{}
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		packet.UnmarshalBinary(encoded)
+	}
+}
+
+func TestStringOfPacketWithUnexpectedValues(t *testing.T) {
+	tests := map[string][]byte{
+		"message type 0":   rawPacket(53, 1, 0, 255),
+		"message type 200": rawPacket(53, 1, 200, 255),
+		"hardware len 200": rawPacket(53, 1, 5, 255),
+	}
+	tests["hardware len 200"][2] = 200
+
+	for name, buf := range tests {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := Decode(buf, len(buf))
+
+			assert.NoError(t, err)
+			assert.NotPanics(t, func() { _ = decoded.String() })
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package packet
+
+import (
+	. "github.com/svishnyakoff/dhcpv4/packet/option"
+)
+
+// Options of the packet are kept parsed, in order they were added or received, and are encoded in the same order.
+// Packet index maps option code to position of the option plus one, so looking option up does not scan options.
+// Options slice is never modified in place, so copies of the packet do not see options set or deleted in each other.
+// The only exception is UnmarshalBinary, that reuses memory of options the packet held.
+
+// AddOption appends option to the packet, even if the packet already has option of the same code. Such options are
+// encoded one after another, and receiver treats them as a single option, see
+// https://datatracker.ietf.org/doc/html/rfc3396. GetOption returns the first of them. Use SetOption to replace the
+// option.
+func (packet *DHCPPacket) AddOption(option DHCPOption) {
+	code := option.Data[0]
+	count := len(packet.options)
+	packet.options = append(packet.options[:count:count], option)
+
+	if packet.index[code] == 0 {
+		packet.index[code] = uint16(len(packet.options))
+	}
+}
+
+// SetOption replaces option of the same code keeping its position, or appends option if the packet does not have it.
+// Other options of the same code, added with AddOption, are removed.
+func (packet *DHCPPacket) SetOption(option DHCPOption) {
+	code := option.Data[0]
+	position := int(packet.index[code])
+
+	if position == 0 {
+		packet.AddOption(option)
+		return
+	}
+
+	options := make([]DHCPOption, 0, len(packet.options))
+	for i, o := range packet.options {
+		switch {
+		case i == position-1:
+			options = append(options, option)
+		case o.Data[0] != code:
+			options = append(options, o)
+		}
+	}
+
+	packet.options = options
+	packet.reindex()
+}
+
+// DeleteOption removes options of given type and tells whether the packet had any
+func (packet *DHCPPacket) DeleteOption(optionType OptionType) bool {
+	if !packet.HasOption(optionType) {
+		return false
+	}
+
+	options := make([]DHCPOption, 0, len(packet.options)-1)
+	for _, o := range packet.options {
+		if OptionType(o.Data[0]) != optionType {
+			options = append(options, o)
+		}
+	}
+
+	packet.options = options
+	packet.reindex()
+
+	return true
+}
+
+// reindex points index of every code to the first option of the code
+func (packet *DHCPPacket) reindex() {
+	packet.index = [256]uint16{}
+	for i := len(packet.options) - 1; i >= 0; i-- {
+		packet.index[packet.options[i].Data[0]] = uint16(i + 1)
+	}
+}
+
+// HasOption tells whether the packet has option of given type
+func (packet DHCPPacket) HasOption(optionType OptionType) bool {
+	return optionType >= 0 && int(optionType) < len(packet.index) && packet.index[optionType] != 0
+}
+
+func (packet DHCPPacket) GetOption(optionType OptionType) *DHCPOption {
+	if !packet.HasOption(optionType) {
+		return nil
+	}
+
+	option := packet.options[packet.index[optionType]-1]
+	return &option
+}
+
+// GetOptions returns options of the packet in order they were added or received. Options of received packet, that
+// are split into several options of the same code, are concatenated in order they appear in, so their value may be
+// longer than 255 bytes.
+func (packet DHCPPacket) GetOptions() []DHCPOption {
+	result := make([]DHCPOption, len(packet.options))
+	copy(result, packet.options)
+
+	return result
+}
+
+// encodedOptionsLen tells how long options are once encoded by appendOptions
+func (packet DHCPPacket) encodedOptionsLen() int {
+	length := 0
+	for _, option := range packet.options {
+		value := len(option.GetRawOptionValue())
+		chunks := (value + 254) / 255
+		if chunks == 0 {
+			chunks = 1
+		}
+
+		length += value + 2*chunks
+	}
+
+	return length
+}
+
+// appendOptions lays options out one after another the way they are sent over the wire and appends them to dst.
+// Option whose value is longer than 255 bytes is split into several options of the same code, see
+// https://datatracker.ietf.org/doc/html/rfc3396
+func (packet DHCPPacket) appendOptions(dst []byte) []byte {
+	for _, option := range packet.options {
+		code, value := option.Data[0], option.GetRawOptionValue()
+		for {
+			chunk := value
+			if len(chunk) > 255 {
+				chunk = chunk[:255]
+			}
+
+			dst = append(dst, code, byte(len(chunk)))
+			dst = append(dst, chunk...)
+			value = value[len(chunk):]
+
+			if len(value) == 0 {
+				break
+			}
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,143 @@
+package packet
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/svishnyakoff/dhcpv4/packet/option"
+)
+
+// Reasons Decode rejects a packet for. Returned errors wrap one of them, use errors.Is to tell the reason.
+var (
+	// ErrTruncated means packet ends in the middle of fixed-length header or option
+	ErrTruncated = errors.New("DHCP packet is truncated")
+	// ErrBadCookie means options field does not start with magic cookie 99.130.83.99
+	ErrBadCookie = errors.New("DHCP packet has invalid magic cookie")
+	// ErrBadOptionLength means option length does not match option type, for example IP address is not 4 bytes long
+	ErrBadOptionLength = errors.New("DHCP option has invalid length")
+)
+
+// length of fixed-length header, that is followed by magic cookie and options
+const headerSize = 236
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// fixedLengths lists options whose value is always of the same length, see https://datatracker.ietf.org/doc/html/rfc2132
+var fixedLengths = map[OptionType]int{
+	SUBNET_MASK:           4,
+	TIME_OFFSET:           4,
+	REQUEST_IP_ADDR:       4,
+	IP_ADDR_LEASE_TIME:    4,
+	OPT_OVERLOAD:          1,
+	DHCP_MESSAGE_TYPE:     1,
+	SERVER_IDENTIFIER:     4,
+	MAX_DHCP_MESSAGE_SIZE: 2,
+	RENEWAL_TIME_VALUE:    4,
+	REBINDING_TIME_VALUE:  4,
+}
+
+// addressListOptions lists options whose value is non-empty list of IPv4 addresses
+var addressListOptions = map[OptionType]bool{
+	ROUTER_OPT:             true,
+	TIME_SERVER_OPT:        true,
+	NAME_SERVER_OPT:        true,
+	DOMAIN_NAME_SERVER_OPT: true,
+	LOG_SERVER_OPT:         true,
+	COOKIE_SERVER_OPT:      true,
+	LPR_SERVER_OPT:         true,
+	IMPRESS_SERVER_OPT:     true,
+}
+
+// addEncodedOptions adds options laid out the way they are sent over the wire, in options field, or in 'file' and
+// 'sname' fields once they are overloaded. PAD options are skipped and parsing stops at END option, so padding after
+// it is ignored. Options field that misses END option is accepted, as some servers do not send it. Options keep
+// referencing data. Length of option value is not validated, as option may be split into several ones, see
+// validateOptionLength.
+func (packet *DHCPPacket) addEncodedOptions(data []byte) error {
+	for i := 0; i < len(data); {
+		optionType := OptionType(data[i])
+
+		switch optionType {
+		case PAD:
+			i++
+			continue
+		case END:
+			return nil
+		}
+
+		if i+1 >= len(data) {
+			return fmt.Errorf("%w: option %d misses length", ErrTruncated, optionType)
+		}
+
+		length := int(data[i+1])
+		next := i + 2 + length
+		if next > len(data) {
+			return fmt.Errorf("%w: option %d is %d bytes long, but only %d bytes left", ErrTruncated, optionType,
+				length, len(data)-i-2)
+		}
+
+		packet.addEncodedOption(data[i:next])
+		i = next
+	}
+
+	return nil
+}
+
+// addEncodedOption adds option being decoded, or appends its value to option of the same code, as split option is
+// treated as a single one. Unlike AddOption, it never keeps options of the same code apart, and it modifies options of
+// the packet in place.
+// https://datatracker.ietf.org/doc/html/rfc3396
+func (packet *DHCPPacket) addEncodedOption(data []byte) {
+	code := data[0]
+
+	if position := packet.index[code]; position != 0 {
+		existing := packet.options[position-1].GetRawOptionValue()
+		value := make([]byte, 0, len(existing)+len(data)-2)
+		value = append(value, existing...)
+		value = append(value, data[2:]...)
+		packet.options[position-1] = NewOption(OptionType(code), value)
+		return
+	}
+
+	packet.options = append(packet.options, DHCPOption{ID: TypeToString(OptionType(code)), Data: data})
+	packet.index[code] = uint16(len(packet.options))
+}
+
+func validateOptionLength(optionType OptionType, length int) error {
+	if expected, ok := fixedLengths[optionType]; ok && length != expected {
+		return fmt.Errorf("%w: option %v is %d bytes long, %d bytes expected", ErrBadOptionLength, optionType, length,
+			expected)
+	}
+
+	if addressListOptions[optionType] && (length == 0 || length%4 != 0) {
+		return fmt.Errorf("%w: option %v is %d bytes long, multiple of 4 expected", ErrBadOptionLength, optionType,
+			length)
+	}
+
+	return nil
+}
+
+// splitOptions splits encoded options into separate options, each one starts with its code and length. PAD options
+// are skipped, and splitting stops at END option or at option that is truncated.
+func splitOptions(options []byte) [][]byte {
+	result := make([][]byte, 0, 10)
+
+	for i := 0; i < len(options); {
+		switch OptionType(options[i]) {
+		case PAD:
+			i++
+			continue
+		case END:
+			return result
+		}
+
+		if i+1 >= len(options) || i+2+int(options[i+1]) > len(options) {
+			return result
+		}
+
+		next := i + 2 + int(options[i+1])
+		result = append(result, options[i:next])
+		i = next
+	}
+
+	return result
+}
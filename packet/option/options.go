@@ -83,6 +83,21 @@ const (
 	CLIENT_IDENTIFIER
 )
 
+const (
+	PAD                    OptionType = 0
+	DOMAIN_SEARCH          OptionType = 119 // https://datatracker.ietf.org/doc/html/rfc3397
+	CLASSLESS_STATIC_ROUTE OptionType = 121 // https://datatracker.ietf.org/doc/html/rfc3442
+	END                    OptionType = 255
+)
+
+// Values of OPT_OVERLOAD option, that tell whether 'file' or 'sname' field of the packet carries options,
+// see https://datatracker.ietf.org/doc/html/rfc2132#section-9.3
+const (
+	OVERLOAD_FILE  = 1
+	OVERLOAD_SNAME = 2
+	OVERLOAD_BOTH  = OVERLOAD_FILE | OVERLOAD_SNAME
+)
+
 var toString = map[OptionType]string{
 	SUBNET_MASK:                  "SUBNET_MASK",
 	TIME_OFFSET:                  "TIME_OFFSET",
@@ -155,6 +170,8 @@ var toString = map[OptionType]string{
 	REBINDING_TIME_VALUE:   "REBINDING_TIME_VALUE",
 	CLASS_IDENTIFIER:       "CLASS_IDENTIFIER",
 	CLIENT_IDENTIFIER:      "CLIENT_IDENTIFIER",
+	DOMAIN_SEARCH:          "DOMAIN_SEARCH",
+	CLASSLESS_STATIC_ROUTE: "CLASSLESS_STATIC_ROUTE",
 }
 
 var toStringConverters = map[OptionType]optionToStringConverter{
@@ -174,11 +191,31 @@ func (t OptionType) String() string {
 	return TypeToString(t)
 }
 
+// DHCPOption holds option code, length and value, the way option is encoded. Value may be longer than 255 bytes, such
+// as large classless route table, then length byte holds 255 and GetRawOptionValue returns the whole value. Such option
+// is split into several options of the same code once it is added to the packet, see
+// https://datatracker.ietf.org/doc/html/rfc3396
 type DHCPOption struct {
 	Data []byte
 	ID   string
 }
 
+// NewOption builds option of given type, value may be of any length
+func NewOption(optionType OptionType, value []byte) DHCPOption {
+	length := len(value)
+	if length > 255 {
+		length = 255
+	}
+
+	data := make([]byte, 0, 2+len(value))
+	data = append(data, byte(optionType), byte(length))
+
+	return DHCPOption{
+		Data: append(data, value...),
+		ID:   optionType.String(),
+	}
+}
+
 type DHCPOptionI interface {
 	GetData() []byte
 	GetId() string
@@ -255,6 +292,7 @@ const (
 	DHCPACK
 	DHCPNAK
 	DHCPRELEASE
+	DHCPINFORM
 	UNKNOWN
 )
 
@@ -302,6 +340,50 @@ func NewServerIdentifierOpt(ip net.IP) DHCPOption {
 	}
 }
 
+// NewParameterRequestListOpt builds option the client uses to request values for specified configuration parameters
+func NewParameterRequestListOpt(options ...OptionType) DHCPOption {
+	value := make([]byte, 0, len(options))
+	for _, o := range options {
+		value = append(value, byte(o))
+	}
+
+	return NewOption(PARAMETER_REQUEST_LIST, value)
+}
+
+// NewMessageOpt builds option server uses to explain why it sent DHCPNAK or client uses to explain DHCPDECLINE
+func NewMessageOpt(message string) DHCPOption {
+	return NewOption(MESSAGE, []byte(message))
+}
+
+// NewIpListOpt builds option whose value is a list of IPv4 addresses, such as ROUTER_OPT or DOMAIN_NAME_SERVER_OPT
+func NewIpListOpt(optionType OptionType, ips ...net.IP) DHCPOption {
+	value := make([]byte, 0, len(ips)*4)
+	for _, ip := range ips {
+		value = append(value, ip.To4()...)
+	}
+
+	return NewOption(optionType, value)
+}
+
+// NewOverloadOpt builds option telling that 'file' or 'sname' field, or both of them, carry options
+func NewOverloadOpt(fields byte) DHCPOption {
+	return DHCPOption{
+		Data: []byte{byte(OPT_OVERLOAD), 1, fields},
+		ID:   OPT_OVERLOAD.String(),
+	}
+}
+
+// NewMaxMessageSizeOpt builds option the client uses to tell the longest DHCP message it accepts
+func NewMaxMessageSizeOpt(size uint16) DHCPOption {
+	data := []byte{byte(MAX_DHCP_MESSAGE_SIZE), 2, 0, 0}
+	binary.BigEndian.PutUint16(data[2:], size)
+
+	return DHCPOption{
+		Data: data,
+		ID:   MAX_DHCP_MESSAGE_SIZE.String(),
+	}
+}
+
 func TypeToString(id OptionType) string {
 	v, ok := toString[id]
 
@@ -320,7 +402,13 @@ func encodeInt(i int, s int) []byte {
 }
 
 func (m MessageType) String() string {
-	t := []string{"DHCPDISCOVER", "DHCPOFFER", "DHCPREQUEST", "DHCPDECLINE", "DHCPACK", "DHCPNAK", "DHCPRELEASE", "UNKNOWN"}
+	t := []string{"DHCPDISCOVER", "DHCPOFFER", "DHCPREQUEST", "DHCPDECLINE", "DHCPACK", "DHCPNAK", "DHCPRELEASE", "DHCPINFORM",
+		"UNKNOWN"}
+
+	// received packet may carry any message type
+	if m < 1 || int(m) > len(t) {
+		return "UNKNOWN " + strconv.Itoa(int(m))
+	}
 
 	return t[m-1]
 }
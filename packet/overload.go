@@ -0,0 +1,131 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	. "github.com/svishnyakoff/dhcpv4/packet/option"
+)
+
+// MinMessageSize is the length of DHCP message every DHCP agent is able to receive. Longer messages are sent only if
+// receiver allows them with MAX_DHCP_MESSAGE_SIZE option, see https://datatracker.ietf.org/doc/html/rfc2131#section-2
+const MinMessageSize = 576
+
+// fixed part of encoded packet besides options: header, magic cookie and "end" tag with padding
+const fixedPacketSize = headerSize + 4 + 3
+
+// MaxMessageSize tells the longest message sender of the packet accepts in reply
+func (packet DHCPPacket) MaxMessageSize() int {
+	o := packet.GetOption(MAX_DHCP_MESSAGE_SIZE)
+	if o == nil || len(o.GetRawOptionValue()) != 2 {
+		return MinMessageSize
+	}
+
+	size := int(binary.BigEndian.Uint16(o.GetRawOptionValue()))
+	if size < MinMessageSize {
+		return MinMessageSize
+	}
+
+	return size
+}
+
+// overload moves options, that would make encoded packet longer than maxSize, to 'file' and then 'sname' field, and
+// tells receiver about it with OPT_OVERLOAD option. Fields that hold boot file name or server name are left intact.
+// Returned packet carries 'file' and 'sname' fields to send, and returned bytes are options field to send.
+// https://datatracker.ietf.org/doc/html/rfc2131#section-4.1
+func (packet DHCPPacket) overload(maxSize int) (DHCPPacket, []byte) {
+	encoded := packet.appendOptions(make([]byte, 0, packet.encodedOptionsLen()))
+	if fixedPacketSize+len(encoded) <= maxSize || packet.HasOption(OPT_OVERLOAD) {
+		return packet, encoded
+	}
+
+	// options field keeps room for OPT_OVERLOAD option itself
+	optionsCapacity := maxSize - fixedPacketSize - 3
+	fileFree, snameFree := isEmptyField(packet.File[:]), isEmptyField(packet.Sname[:])
+	var options, file, sname []byte
+
+	// receiver concatenates parts of split option in order of options, 'file' and 'sname' fields, so once part of
+	// option is moved to 'file' field, the rest of it never goes back to options field
+	// https://datatracker.ietf.org/doc/html/rfc3396#section-5
+	const inOptions, inFile, inSname = 0, 1, 2
+	placement := make(map[byte]int)
+
+	for _, o := range splitOptions(encoded) {
+		switch {
+		case placement[o[0]] == inOptions && len(options)+len(o) <= optionsCapacity:
+			options = append(options, o...)
+		case placement[o[0]] <= inFile && fileFree && len(file)+len(o) < len(packet.File):
+			file = append(file, o...)
+			placement[o[0]] = inFile
+		case snameFree && len(sname)+len(o) < len(packet.Sname):
+			sname = append(sname, o...)
+			placement[o[0]] = inSname
+		default:
+			// there is no room left, so the packet is sent as it is and exceeds maxSize
+			return packet, encoded
+		}
+	}
+
+	var overloaded byte
+	if len(file) > 0 {
+		overloaded |= OVERLOAD_FILE
+		packet.File = [128]byte{}
+		copy(packet.File[:], append(file, byte(END)))
+	}
+
+	if len(sname) > 0 {
+		overloaded |= OVERLOAD_SNAME
+		packet.Sname = [64]byte{}
+		copy(packet.Sname[:], append(sname, byte(END)))
+	}
+
+	if overloaded == 0 {
+		return packet, encoded
+	}
+
+	return packet, append(NewOverloadOpt(overloaded).Data, options...)
+}
+
+// mergeOverloadedOptions appends options carried by 'file' and then 'sname' field to options of the packet, if
+// OPT_OVERLOAD option says so. Overloaded fields are cleared, as they hold neither boot file name nor server name.
+// https://datatracker.ietf.org/doc/html/rfc2131#section-4.1
+func (packet *DHCPPacket) mergeOverloadedOptions() error {
+	overload := packet.GetOption(OPT_OVERLOAD)
+	if overload == nil {
+		return nil
+	}
+
+	value := overload.GetRawOptionValue()
+	if len(value) != 1 {
+		return fmt.Errorf("%w: option overload is %d bytes long", ErrBadOptionLength, len(value))
+	}
+
+	packet.DeleteOption(OPT_OVERLOAD)
+
+	if value[0]&OVERLOAD_FILE != 0 {
+		file := packet.File
+		packet.File = [128]byte{}
+		if err := packet.addEncodedOptions(file[:]); err != nil {
+			return fmt.Errorf("malformed options in 'file' field: %w", err)
+		}
+	}
+
+	if value[0]&OVERLOAD_SNAME != 0 {
+		sname := packet.Sname
+		packet.Sname = [64]byte{}
+		if err := packet.addEncodedOptions(sname[:]); err != nil {
+			return fmt.Errorf("malformed options in 'sname' field: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func isEmptyField(field []byte) bool {
+	for _, b := range field {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
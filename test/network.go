@@ -0,0 +1,308 @@
+package test
+
+import (
+	"errors"
+	netUtils "github.com/svishnyakoff/dhcpv4/util/net-utils"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var errEndpointClosed = errors.New("virtual network endpoint has been closed")
+
+// VirtualNetwork is an in-process broadcast domain. Endpoints attached to the network exchange UDP datagrams without
+// touching real sockets, so DHCP clients, servers and relays could be tested without ports and root privileges.
+//
+// Delivery is reliable and instant by default. Latency, Jitter, LossRate and DuplicateRate let tests simulate
+// unreliable network. Non zero Jitter makes datagrams to be delivered out of order.
+type VirtualNetwork struct {
+	Latency       time.Duration // delay applied to every delivered datagram
+	Jitter        time.Duration // random extra delay within [0, Jitter) interval
+	LossRate      float64       // probability of datagram to be lost, 0 <= LossRate <= 1
+	DuplicateRate float64       // probability of datagram to be delivered twice, 0 <= DuplicateRate <= 1
+
+	lock      *sync.Mutex
+	rand      *rand.Rand
+	endpoints []*Endpoint
+	hosts     []net.IP
+	hwAddrs   map[string]net.HardwareAddr
+}
+
+type datagram struct {
+	data []byte
+	src  net.UDPAddr
+}
+
+// Endpoint is a UDP socket attached to VirtualNetwork. It implements net.PacketConn.
+type Endpoint struct {
+	network   *VirtualNetwork
+	addr      net.UDPAddr
+	groups    []net.IP
+	inbox     chan datagram
+	closed    chan int
+	closeOnce *sync.Once
+	lock      *sync.Mutex
+	deadline  time.Time
+}
+
+type timeoutError struct{}
+
+func (e timeoutError) Error() string {
+	return "i/o timeout"
+}
+
+func (e timeoutError) Timeout() bool {
+	return true
+}
+
+func (e timeoutError) Temporary() bool {
+	return true
+}
+
+func NewVirtualNetwork() *VirtualNetwork {
+	return NewVirtualNetworkWithSeed(time.Now().UnixNano())
+}
+
+// NewVirtualNetworkWithSeed creates network which makes loss, duplication and jitter decisions deterministic for
+// given seed.
+func NewVirtualNetworkWithSeed(seed int64) *VirtualNetwork {
+	return &VirtualNetwork{
+		lock:    &sync.Mutex{},
+		rand:    rand.New(rand.NewSource(seed)),
+		hwAddrs: make(map[string]net.HardwareAddr),
+	}
+}
+
+// Attach creates new endpoint bound to given address. Endpoint bound to 0.0.0.0 receives unicast datagrams sent to
+// any address on its port, the same way as socket bound to INADDR_ANY does.
+func (n *VirtualNetwork) Attach(addr net.UDPAddr) *Endpoint {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	e := &Endpoint{
+		network:   n,
+		addr:      net.UDPAddr{IP: addr.IP.To4(), Port: addr.Port},
+		inbox:     make(chan datagram, 100),
+		closed:    make(chan int),
+		closeOnce: &sync.Once{},
+		lock:      &sync.Mutex{},
+	}
+
+	n.endpoints = append(n.endpoints, e)
+
+	return e
+}
+
+// ClaimAddress marks IP address as used by some host on the network, so IsUniqueIp reports the address as taken.
+func (n *VirtualNetwork) ClaimAddress(ip net.IP) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.hosts = append(n.hosts, ip.To4())
+}
+
+// AddHost claims IP address the same way as ClaimAddress and makes host resolvable by ResolveHardwareAddr
+func (n *VirtualNetwork) AddHost(ip net.IP, hardwareAddr net.HardwareAddr) {
+	n.ClaimAddress(ip)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.hwAddrs[ip.To4().String()] = hardwareAddr
+}
+
+// ResolveHardwareAddr is a counterpart of ARP request. It reports hardware address of the host added with AddHost,
+// or net_utils.ErrNoArpReply if there is no such host. Sender address is ignored.
+func (n *VirtualNetwork) ResolveHardwareAddr(ip net.IP, sender net.IP) (net.HardwareAddr, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	hardwareAddr, ok := n.hwAddrs[ip.To4().String()]
+	if !ok {
+		return nil, netUtils.ErrNoArpReply
+	}
+
+	return hardwareAddr, nil
+}
+
+// IsUniqueIp is a counterpart of ARP probe. It reports whether IP address is not used by any endpoint or host
+// attached to the network.
+func (n *VirtualNetwork) IsUniqueIp(ip net.IP) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, host := range n.hosts {
+		if host.Equal(ip) {
+			return false
+		}
+	}
+
+	for _, e := range n.endpoints {
+		if e.addr.IP.Equal(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (n *VirtualNetwork) detach(e *Endpoint) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for i, endpoint := range n.endpoints {
+		if endpoint == e {
+			n.endpoints = append(n.endpoints[:i], n.endpoints[i+1:]...)
+			return
+		}
+	}
+}
+
+func (n *VirtualNetwork) send(src *Endpoint, data []byte, dst net.UDPAddr) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, e := range n.endpoints {
+		if e == src || !e.accepts(dst) {
+			continue
+		}
+
+		if n.rand.Float64() < n.LossRate {
+			continue
+		}
+
+		copies := 1
+		if n.rand.Float64() < n.DuplicateRate {
+			copies++
+		}
+
+		for i := 0; i < copies; i++ {
+			d := datagram{data: append([]byte{}, data...), src: src.addr}
+			delay := n.Latency
+
+			if n.Jitter > 0 {
+				delay += time.Duration(n.rand.Int63n(int64(n.Jitter)))
+			}
+
+			if delay <= 0 {
+				e.deliver(d)
+			} else {
+				receiver := e
+				time.AfterFunc(delay, func() {
+					receiver.deliver(d)
+				})
+			}
+		}
+	}
+}
+
+func (e *Endpoint) accepts(dst net.UDPAddr) bool {
+	if e.addr.Port != dst.Port {
+		return false
+	}
+
+	if dst.IP.Equal(net.IPv4bcast) {
+		return true
+	}
+
+	if dst.IP.IsMulticast() {
+		for _, group := range e.groups {
+			if group.Equal(dst.IP) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return e.addr.IP.IsUnspecified() || e.addr.IP.Equal(dst.IP)
+}
+
+func (e *Endpoint) deliver(d datagram) {
+	select {
+	case <-e.closed:
+	case e.inbox <- d:
+	default:
+		// inbox is full, datagram is dropped as it would be dropped by real socket
+	}
+}
+
+// JoinGroup subscribes endpoint to datagrams sent to multicast group.
+func (e *Endpoint) JoinGroup(group net.IP) {
+	e.network.lock.Lock()
+	defer e.network.lock.Unlock()
+
+	e.groups = append(e.groups, group.To4())
+}
+
+func (e *Endpoint) ReadFrom(b []byte) (int, net.Addr, error) {
+	e.lock.Lock()
+	deadline := e.deadline
+	e.lock.Unlock()
+
+	var timeout <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case d := <-e.inbox:
+		src := d.src
+		return copy(b, d.data), &src, nil
+	case <-e.closed:
+		return 0, nil, errEndpointClosed
+	case <-timeout:
+		return 0, nil, timeoutError{}
+	}
+}
+
+func (e *Endpoint) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-e.closed:
+		return 0, errEndpointClosed
+	default:
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("virtual network supports UDP addresses only")
+	}
+
+	e.network.send(e, b, net.UDPAddr{IP: udpAddr.IP.To4(), Port: udpAddr.Port})
+
+	return len(b), nil
+}
+
+func (e *Endpoint) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		e.network.detach(e)
+	})
+
+	return nil
+}
+
+func (e *Endpoint) LocalAddr() net.Addr {
+	addr := e.addr
+	return &addr
+}
+
+func (e *Endpoint) SetDeadline(t time.Time) error {
+	return e.SetReadDeadline(t)
+}
+
+func (e *Endpoint) SetReadDeadline(t time.Time) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.deadline = t
+	return nil
+}
+
+func (e *Endpoint) SetWriteDeadline(t time.Time) error {
+	return nil
+}
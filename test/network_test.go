@@ -0,0 +1,94 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBroadcastIsDeliveredToAllEndpointsOnPort(t *testing.T) {
+	network := NewVirtualNetwork()
+	client := network.Attach(net.UDPAddr{IP: net.IPv4zero, Port: 68})
+	server1 := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 67})
+	server2 := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 67})
+	other := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.3"), Port: 69})
+
+	client.WriteTo([]byte{1}, &net.UDPAddr{IP: net.IPv4bcast, Port: 67})
+
+	assert.Equal(t, []byte{1}, read(t, server1))
+	assert.Equal(t, []byte{1}, read(t, server2))
+	assertNothingReceived(t, other)
+}
+
+func TestUnicastIsDeliveredToAddressOwner(t *testing.T) {
+	network := NewVirtualNetwork()
+	client := network.Attach(net.UDPAddr{IP: net.IPv4zero, Port: 68})
+	server1 := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 67})
+	server2 := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 67})
+
+	client.WriteTo([]byte{1}, &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 67})
+	server1.WriteTo([]byte{2}, &net.UDPAddr{IP: net.ParseIP("10.0.0.100"), Port: 68})
+
+	assert.Equal(t, []byte{1}, read(t, server2))
+	assert.Equal(t, []byte{2}, read(t, client))
+	assertNothingReceived(t, server1)
+}
+
+func TestMulticastIsDeliveredToGroupMembers(t *testing.T) {
+	network := NewVirtualNetwork()
+	client := network.Attach(net.UDPAddr{IP: net.IPv4zero, Port: 68})
+	member := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 67})
+	nonMember := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 67})
+	member.JoinGroup(net.IPv4(224, 0, 0, 1))
+
+	client.WriteTo([]byte{1}, &net.UDPAddr{IP: net.IPv4(224, 0, 0, 1), Port: 67})
+
+	assert.Equal(t, []byte{1}, read(t, member))
+	assertNothingReceived(t, nonMember)
+}
+
+func TestLossAndDuplication(t *testing.T) {
+	network := NewVirtualNetworkWithSeed(1)
+	client := network.Attach(net.UDPAddr{IP: net.IPv4zero, Port: 68})
+	server := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 67})
+
+	network.LossRate = 1
+	client.WriteTo([]byte{1}, &net.UDPAddr{IP: net.IPv4bcast, Port: 67})
+	assertNothingReceived(t, server)
+
+	network.LossRate = 0
+	network.DuplicateRate = 1
+	client.WriteTo([]byte{2}, &net.UDPAddr{IP: net.IPv4bcast, Port: 67})
+	assert.Equal(t, []byte{2}, read(t, server))
+	assert.Equal(t, []byte{2}, read(t, server))
+}
+
+func TestLatency(t *testing.T) {
+	network := NewVirtualNetwork()
+	network.Latency = 100 * time.Millisecond
+	client := network.Attach(net.UDPAddr{IP: net.IPv4zero, Port: 68})
+	server := network.Attach(net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 67})
+
+	start := time.Now()
+	client.WriteTo([]byte{1}, &net.UDPAddr{IP: net.IPv4bcast, Port: 67})
+
+	assert.Equal(t, []byte{1}, read(t, server))
+	assert.True(t, time.Since(start) >= network.Latency)
+}
+
+func read(t *testing.T, e *Endpoint) []byte {
+	buf := make([]byte, 100)
+	e.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := e.ReadFrom(buf)
+
+	assert.NoError(t, err)
+	return buf[:n]
+}
+
+func assertNothingReceived(t *testing.T, e *Endpoint) {
+	e.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err := e.ReadFrom(make([]byte, 100))
+
+	assert.Equal(t, timeoutError{}, err)
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"github.com/svishnyakoff/dhcpv4/util/converter"
+	"log"
+	"net"
+	"time"
+)
+
+// Relay is a BOOTP relay agent that connects two virtual networks. It forwards client broadcasts to DHCP server
+// and server replies back to clients as described in https://datatracker.ietf.org/doc/html/rfc1542#section-4
+type Relay struct {
+	addr       net.IP
+	serverAddr net.IP
+	clientConn *Endpoint
+	serverConn *Endpoint
+}
+
+// NewRelay creates relay agent with address addr on both client and server networks. Client requests are forwarded
+// to serverAddr.
+func NewRelay(clientNetwork *VirtualNetwork, serverNetwork *VirtualNetwork, addr net.IP, serverAddr net.IP) *Relay {
+	return &Relay{
+		addr:       addr.To4(),
+		serverAddr: serverAddr.To4(),
+		clientConn: clientNetwork.Attach(net.UDPAddr{IP: addr, Port: 67}),
+		serverConn: serverNetwork.Attach(net.UDPAddr{IP: addr, Port: 67}),
+	}
+}
+
+func (r *Relay) Start() {
+	go r.forward(r.clientConn, r.forwardToServer)
+	go r.forward(r.serverConn, r.forwardToClient)
+}
+
+func (r *Relay) Stop() {
+	r.clientConn.Close()
+	r.serverConn.Close()
+}
+
+func (r *Relay) forward(conn *Endpoint, handle func(p packet.DHCPPacket)) {
+	buf := make([]byte, 2000)
+
+	for {
+		conn.SetReadDeadline(time.Time{})
+		n, _, err := conn.ReadFrom(buf)
+
+		if err == errEndpointClosed {
+			return
+		}
+
+		if err != nil {
+			log.Println("relay failed to read packet", err)
+			continue
+		}
+
+		p, err := packet.Decode(buf, n)
+		if err != nil {
+			log.Println("relay received malformed packet", err)
+			continue
+		}
+
+		handle(p)
+	}
+}
+
+func (r *Relay) forwardToServer(p packet.DHCPPacket) {
+	if p.Op != packet.REQUEST {
+		return
+	}
+
+	if isEmptyArray(p.Giaddr[:]) {
+		p.Giaddr = converter.IP2Array(r.addr)
+	}
+	p.Hops++
+
+	r.serverConn.WriteTo(p.Encode(), &net.UDPAddr{IP: r.serverAddr, Port: 67})
+}
+
+func (r *Relay) forwardToClient(p packet.DHCPPacket) {
+	if p.Op != packet.REPLY {
+		return
+	}
+
+	dst := &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	if !isEmptyArray(p.Ciaddr[:]) && !p.BroadcastFlag() {
+		dst.IP = net.IP(p.Ciaddr[:])
+	}
+
+	r.clientConn.WriteTo(p.Encode(), dst)
+}
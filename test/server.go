@@ -35,10 +35,13 @@ type DHCPServer struct {
 	stopSignal      chan int
 	port            int
 	addr            net.IP
-	conn            *ipv4.PacketConn
-	multicastConn   *ipv4.PacketConn
+	network         *VirtualNetwork
+	conn            net.PacketConn
+	multicastConn   net.PacketConn
 	packetChan      chan *incomingData
 	stopAction      *sync.Once
+	// lock guards ReceivedPackets and SentPackets from being written after Stop closed them
+	lock *sync.Mutex
 }
 
 func NewDHCPServer(addr net.IP, port int) DHCPServer {
@@ -52,9 +55,19 @@ func NewDHCPServer(addr net.IP, port int) DHCPServer {
 		addr:            addr,
 		packetChan:      make(chan *incomingData, 100),
 		stopAction:      &sync.Once{},
+		lock:            &sync.Mutex{},
 	}
 }
 
+// NewVirtualDHCPServer creates server that is attached to virtual network instead of real sockets. The server listens
+// on port 67 and replies to clients according to https://datatracker.ietf.org/doc/html/rfc2131#section-4.1
+func NewVirtualDHCPServer(network *VirtualNetwork, addr net.IP) DHCPServer {
+	server := NewDHCPServer(addr, 67)
+	server.network = network
+
+	return server
+}
+
 func (s *DHCPServer) Listen() {
 	addr := net.UDPAddr{
 		Port: s.port,
@@ -63,27 +76,18 @@ func (s *DHCPServer) Listen() {
 
 	log.Println("start listening:", addr.IP, addr.Port)
 
-	// By some reason I was not able to bind single connection to both unicast and multicast addresses. When i tried
-	// bind to unicast and join to multicast group, server did not receive multicast traffic.
-	//
-	// My second attempt was to spawn two connections: one for multicast and one for unicast.
-	// But then again when I tried to run multiple servers I got error that address is already used.
-	//
-	// Eventually I succeeded with two connections by setting special SO_REUSEPORT option that lets reuse port.
-	// That should not be an issue as DHCP server is intended for tests only.
-	s.multicastConn = s.initConnection(net.UDPAddr{
-		Port: s.port,
-		IP:   net.IPv4(224, 0, 0, 1),
-	})
-	s.conn = s.initConnection(net.UDPAddr{
-		Port: s.port,
-		IP:   s.addr,
-	})
+	if s.network != nil {
+		s.conn = s.network.Attach(addr)
+	} else {
+		s.listenSockets(addr)
+	}
 
 	log.Println("DHCP server is listening")
 
 	go s.listenConnection(s.conn, true)
-	go s.listenConnection(s.multicastConn, false)
+	if s.multicastConn != nil {
+		go s.listenConnection(s.multicastConn, false)
+	}
 
 	go func() {
 		for !s.isServerStopped() {
@@ -113,7 +117,23 @@ func (s *DHCPServer) Listen() {
 	}()
 }
 
-func (s *DHCPServer) initConnection(addr net.UDPAddr) *ipv4.PacketConn {
+func (s *DHCPServer) listenSockets(addr net.UDPAddr) {
+	// By some reason I was not able to bind single connection to both unicast and multicast addresses. When i tried
+	// bind to unicast and join to multicast group, server did not receive multicast traffic.
+	//
+	// My second attempt was to spawn two connections: one for multicast and one for unicast.
+	// But then again when I tried to run multiple servers I got error that address is already used.
+	//
+	// Eventually I succeeded with two connections by setting special SO_REUSEPORT option that lets reuse port.
+	// That should not be an issue as DHCP server is intended for tests only.
+	s.multicastConn = s.initConnection(net.UDPAddr{
+		Port: s.port,
+		IP:   net.IPv4(224, 0, 0, 1),
+	})
+	s.conn = s.initConnection(addr)
+}
+
+func (s *DHCPServer) initConnection(addr net.UDPAddr) net.PacketConn {
 
 	lc := net.ListenConfig{
 		Control: func(network, address string, c syscall.RawConn) error {
@@ -130,12 +150,12 @@ func (s *DHCPServer) initConnection(addr net.UDPAddr) *ipv4.PacketConn {
 
 	lp, err := lc.ListenPacket(context.Background(), "udp", addr.String())
 
-	conn := lp.(*net.UDPConn)
-
 	if err != nil {
 		log.Panic("error binding to multicast group:", err)
 	}
 
+	conn := lp.(*net.UDPConn)
+
 	packetConn := ipv4.NewPacketConn(conn)
 
 	if addr.IP.IsMulticast() {
@@ -145,10 +165,10 @@ func (s *DHCPServer) initConnection(addr net.UDPAddr) *ipv4.PacketConn {
 	packetConn.SetMulticastInterface(s.getInterface())
 	packetConn.SetMulticastLoopback(true)
 
-	return packetConn
+	return conn
 }
 
-func (s *DHCPServer) listenConnection(conn *ipv4.PacketConn, isUnicast bool) {
+func (s *DHCPServer) listenConnection(conn net.PacketConn, isUnicast bool) {
 	for {
 		data, addr, err := s.readUdp(conn)
 
@@ -165,6 +185,9 @@ func (s *DHCPServer) listenConnection(conn *ipv4.PacketConn, isUnicast bool) {
 func (s *DHCPServer) Stop() {
 	if !s.isServerStopped() {
 		s.stopAction.Do(func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
 			s.stopped.Store(true)
 			if s.conn != nil {
 				s.conn.Close()
@@ -190,13 +213,20 @@ func (s *DHCPServer) HandleCommands(data incomingData, r Reply) {
 		panic(err)
 	}
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.isServerStopped() {
+		return
+	}
+
 	s.ReceivedPackets <- pack
 
 	answer, ok := r(pack)
 
 	// handle for packet is missing, we will just store the fact we received packet but won't respond back
 	if ok {
-		_, err = s.conn.WriteTo(answer.Encode(), nil, addr)
+		_, err = s.conn.WriteTo(answer.EncodeWithMaxSize(pack.MaxMessageSize()), s.replyAddr(pack, addr))
 
 		s.SentPackets <- answer
 
@@ -206,11 +236,29 @@ func (s *DHCPServer) HandleCommands(data incomingData, r Reply) {
 	}
 }
 
-func (s *DHCPServer) readUdp(conn *ipv4.PacketConn) ([]byte, net.Addr, error) {
+// replyAddr picks address server replies to. Server that listens on real sockets always replies to the sender,
+// otherwise the address is chosen according to https://datatracker.ietf.org/doc/html/rfc2131#section-4.1
+func (s *DHCPServer) replyAddr(request packet.DHCPPacket, src net.Addr) net.Addr {
+	if s.network == nil {
+		return src
+	}
+
+	if !isEmptyArray(request.Giaddr[:]) {
+		return &net.UDPAddr{IP: net.IP(request.Giaddr[:]), Port: 67}
+	}
+
+	if !isEmptyArray(request.Ciaddr[:]) {
+		return &net.UDPAddr{IP: net.IP(request.Ciaddr[:]), Port: 68}
+	}
+
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+}
+
+func (s *DHCPServer) readUdp(conn net.PacketConn) ([]byte, net.Addr, error) {
 	buffer := make([]byte, 2000)
 
 	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
-	bytesRead, _, addr, err := conn.ReadFrom(buffer)
+	bytesRead, addr, err := conn.ReadFrom(buffer)
 
 	if os.IsTimeout(err) || bytesRead <= 0 {
 		if s.isServerStopped() {
@@ -274,9 +322,17 @@ func (s *DHCPServer) AddReplyWithDelay(p packet.DHCPPacket, delay time.Duration,
 	s.replies <- reply
 }
 
-func (s *DHCPServer) getInterface() (ifi *net.Interface) {
-	ifi, _ = net.InterfaceByName("lo0")
-	return
+// getInterface provides loopback interface, that is "lo0" on macOS and "lo" on Linux
+func (s *DHCPServer) getInterface() *net.Interface {
+	interfaces, _ := net.Interfaces()
+
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return &ifi
+		}
+	}
+
+	return nil
 }
 
 func (s *DHCPServer) ReadAllReceivedPackets() []packet.DHCPPacket {
@@ -346,8 +402,8 @@ func orDefault(a interface{}, def interface{}) interface{} {
 }
 
 func isEmptyArray(a []byte) bool {
-	for k := range a {
-		if k != 0 {
+	for _, b := range a {
+		if b != 0 {
 			return false
 		}
 	}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"fmt"
+	"github.com/svishnyakoff/dhcpv4/packet"
+	"log"
+	"net"
+	"time"
+)
+
+// VirtualTransport lets DHCP client talk to servers over VirtualNetwork. It satisfies core.Transport interface.
+type VirtualTransport struct {
+	network  *VirtualNetwork
+	endpoint *Endpoint
+}
+
+func NewVirtualTransport(network *VirtualNetwork) *VirtualTransport {
+	return &VirtualTransport{network: network}
+}
+
+func (t *VirtualTransport) Listen() error {
+	t.endpoint = t.network.Attach(net.UDPAddr{IP: net.IPv4zero, Port: 68})
+	return nil
+}
+
+func (t *VirtualTransport) Send(p packet.DHCPPacket, addr net.IP) error {
+	log.Printf("--> %v\n%v\n\n", p.GetMessageType(), p)
+
+	if _, err := t.endpoint.WriteTo(p.Encode(), &net.UDPAddr{IP: addr, Port: 67}); err != nil {
+		return fmt.Errorf("error writing dhcp data to virtual network: %v", err)
+	}
+
+	return nil
+}
+
+func (t *VirtualTransport) Receive(buf []byte, deadline time.Time) (int, error) {
+	t.endpoint.SetReadDeadline(deadline)
+	n, _, err := t.endpoint.ReadFrom(buf)
+
+	return n, err
+}
+
+func (t *VirtualTransport) Stop() {
+	if t.endpoint != nil {
+		t.endpoint.Close()
+	}
+}
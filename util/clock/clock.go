@@ -0,0 +1,60 @@
+package clock
+
+import (
+	"time"
+)
+
+// Clock is a source of current time and timers. Processing engine, lease and timers utilities use Clock instead of
+// time package, so tests could replace real time with FakeClock.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Timer mirrors time.Timer API. Timer channel is exposed through C method as interfaces cannot declare fields.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+// NewRealClock creates clock backed by time package
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+func (c realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{timer: time.NewTimer(d)}
+}
+
+func (c realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (c realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) bool {
+	return t.timer.Reset(d)
+}
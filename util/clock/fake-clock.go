@@ -0,0 +1,160 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock that stands still until test advances it. Timers created by the clock fire once clock is
+// advanced past their deadline, so hours of lease lifetime could be simulated in milliseconds.
+type FakeClock struct {
+	lock   *sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		lock: &sync.Mutex{},
+		now:  now,
+	}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.schedule(t, d)
+
+	return t
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves clock forward by d and fires all timers which deadline is reached
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	c.fireExpiredTimers()
+}
+
+// AdvanceToNextTimer moves clock to the deadline of the closest active timer and fires it. It returns false if there
+// is no active timer.
+func (c *FakeClock) AdvanceToNextTimer() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var next *fakeTimer
+	for _, t := range c.timers {
+		if next == nil || t.deadline.Before(next.deadline) {
+			next = t
+		}
+	}
+
+	if next == nil {
+		return false
+	}
+
+	if next.deadline.After(c.now) {
+		c.now = next.deadline
+	}
+	c.fireExpiredTimers()
+
+	return true
+}
+
+// ActiveTimers reports number of timers that have not fired or been stopped yet. Tests use it to find out whether
+// code under test is blocked waiting for a timer before advancing the clock.
+func (c *FakeClock) ActiveTimers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.timers)
+}
+
+// schedule activates timer. Must be called while holding the clock lock.
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	t.active = true
+	c.timers = append(c.timers, t)
+	c.fireExpiredTimers()
+}
+
+// unschedule deactivates timer and reports whether timer was active. Must be called while holding the clock lock.
+func (c *FakeClock) unschedule(t *fakeTimer) bool {
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+
+	wasActive := t.active
+	t.active = false
+
+	return wasActive
+}
+
+func (c *FakeClock) fireExpiredTimers() {
+	pending := c.timers[:0]
+
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		t.active = false
+		select {
+		case t.c <- c.now:
+		default:
+			// previous tick was not consumed, the same way as time.Timer drops it
+		}
+	}
+
+	c.timers = pending
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	wasActive := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+
+	return wasActive
+}
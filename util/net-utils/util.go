@@ -2,8 +2,11 @@ package net_utils
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/mdlayher/arp"
 	"github.com/mdlayher/ethernet"
+	"github.com/mdlayher/raw"
 	"github.com/svishnyakoff/dhcpv4/config"
 	"log"
 	"math/rand"
@@ -109,6 +112,88 @@ func IsUniqueIp(addr net.IP, config config.DHCPConfig) bool {
 	return !arpCheck(addr, i, client)
 }
 
+// ErrNoArpReply is returned by ResolveHardwareAddr if no host replied to ARP request
+var ErrNoArpReply = errors.New("no host replied to ARP request")
+
+// ResolveHardwareAddr finds hardware address of the host with given IP address on local network segment with ARP
+// request sent on behalf of sender address. The request is written to raw socket, so interface does not need to have
+// IPv4 address configured, and sender may be the address client is about to reuse, see
+// https://datatracker.ietf.org/doc/html/rfc4436#section-2.2. Returned error is ErrNoArpReply if no host replies, any
+// other error means the request could not be sent.
+func ResolveHardwareAddr(addr net.IP, sender net.IP, config config.DHCPConfig) (net.HardwareAddr, error) {
+	i, err := net.InterfaceByName(config.InterfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("could retrieve interface by name %v: %w", config.InterfaceName, err)
+	}
+
+	request, err := arp.NewPacket(arp.OperationRequest, i.HardwareAddr, sender.To4(), ethernet.Broadcast, addr.To4())
+	if err != nil {
+		return nil, fmt.Errorf("could not create arp request: %w", err)
+	}
+
+	payload, err := request.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("could not encode arp request: %w", err)
+	}
+
+	frame, err := (&ethernet.Frame{
+		Destination: ethernet.Broadcast,
+		Source:      i.HardwareAddr,
+		EtherType:   ethernet.EtherTypeARP,
+		Payload:     payload,
+	}).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("could not encode arp request: %w", err)
+	}
+
+	conn, err := raw.ListenPacket(i, uint16(ethernet.EtherTypeARP), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open raw socket: %w", err)
+	}
+	defer conn.Close()
+
+	timeout := 200 * time.Millisecond
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("could not set raw socket deadline: %w", err)
+	}
+
+	if _, err := conn.WriteTo(frame, &raw.Addr{HardwareAddr: ethernet.Broadcast}); err != nil {
+		return nil, fmt.Errorf("could not send arp request: %w", err)
+	}
+
+	// ARP reply fits into minimal ethernet frame
+	buf := make([]byte, 128)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if os.IsTimeout(err) {
+			return nil, ErrNoArpReply
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read arp reply: %w", err)
+		}
+
+		reply, ok := parseArpReply(buf[:n])
+		if ok && reply.SenderIP.Equal(addr) {
+			return reply.SenderHardwareAddr, nil
+		}
+	}
+}
+
+// parseArpReply decodes ARP reply carried by ethernet frame, it reports false if frame carries anything else
+func parseArpReply(data []byte) (*arp.Packet, bool) {
+	frame := new(ethernet.Frame)
+	if err := frame.UnmarshalBinary(data); err != nil || frame.EtherType != ethernet.EtherTypeARP {
+		return nil, false
+	}
+
+	packet := new(arp.Packet)
+	if err := packet.UnmarshalBinary(frame.Payload); err != nil || packet.Operation != arp.OperationReply {
+		return nil, false
+	}
+
+	return packet, true
+}
+
 func arpCheck(ip net.IP, i *net.Interface, client *arp.Client) bool {
 	packet, _ := arp.NewPacket(arp.OperationRequest, i.HardwareAddr, net.IPv4zero, ethernet.Broadcast, ip)
 
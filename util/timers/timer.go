@@ -1,20 +1,23 @@
 package timers
 
 import (
+	"github.com/svishnyakoff/dhcpv4/util/clock"
 	"math"
+	"math/rand"
 	"time"
 )
 
-// NextRebindingMoment calculates next time we need renew or rebound lease.
-// The calculated time will be somewhere between now and given time t
-//
-// @t is either T2 or end of Lease time
-func NextRebindingMoment(t time.Time) time.Duration {
-	now := time.Now()
-	return time.Duration(math.Max(float64(60*time.Second), float64(t.Sub(now)/2)))
+// RandomDuration returns uniformly distributed duration from [min, max] interval. If max is less than min, min is
+// returned.
+func RandomDuration(min time.Duration, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+
+	return min + time.Duration(rand.Int63n(int64(max-min)+1))
 }
 
-func SafeReset(timer *time.Timer, d time.Duration) time.Duration {
+func SafeReset(timer clock.Timer, d time.Duration) time.Duration {
 	SafeStop(timer)
 
 	timer.Reset(d)
@@ -22,13 +25,57 @@ func SafeReset(timer *time.Timer, d time.Duration) time.Duration {
 	return d
 }
 
-func SafeStop(timer *time.Timer) {
+func SafeStop(timer clock.Timer) {
 	if !timer.Stop() {
 		select {
-		case <-timer.C:
+		case <-timer.C():
 			// draining timers channel
 		default:
 			// timer channel already drained
 		}
 	}
 }
+
+// Backoff produces delays between retransmissions of DHCP messages as described in
+// https://datatracker.ietf.org/doc/html/rfc2131#section-4.1
+// The delay starts at Initial and doubles after every retransmission until it reaches Max. Every delay is randomized
+// by uniform value from [-Randomization, +Randomization] interval.
+type Backoff struct {
+	Initial       time.Duration
+	Max           time.Duration
+	Randomization time.Duration
+	next          time.Duration
+}
+
+func NewBackoff(initial time.Duration, max time.Duration, randomization time.Duration) *Backoff {
+	return &Backoff{
+		Initial:       initial,
+		Max:           max,
+		Randomization: randomization,
+	}
+}
+
+// Next returns delay before next retransmission. Non-positive Initial and Max fall back to 4 and 64 seconds RFC 2131
+// suggests, so retransmissions never run in a tight loop.
+func (b *Backoff) Next() time.Duration {
+	initial, max := b.Initial, b.Max
+	if initial <= 0 {
+		initial = 4 * time.Second
+	}
+	if max <= 0 {
+		max = 64 * time.Second
+	}
+
+	if b.next == 0 {
+		b.next = initial
+	}
+
+	delay := b.next
+	b.next = time.Duration(math.Min(float64(2*b.next), float64(max)))
+
+	if b.Randomization > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*b.Randomization)+1)) - b.Randomization
+	}
+
+	return time.Duration(math.Max(float64(delay), 0))
+}